@@ -0,0 +1,157 @@
+// Package agent keeps derived keys for seof command line invocations, like ssh-agent: a daemon deriving keys through a
+// seof.KeyCache, serving its user in a Unix socket. Passwords are sent to the agent, the socket is only accessible to
+// the user running it. The agent also gives the password salt for new files (see seof.WithPasswordSalt): files
+// created with the same password while the agent keeps it share their password key, derived once by the agent.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kuking/seof"
+	"github.com/kuking/seof/crypto"
+)
+
+// SocketEnv is the environment variable with the agent socket path
+const SocketEnv = "SEOF_AGENT_SOCK"
+
+// Requests and responses are one JSON object per connection, binary values are base64 (as encoding/json does):
+//
+//	{"op": "derive", "scrypt": {"N": 65536, "R": 64, "P": 1}, "password": "...", "salt": "...", "key_length": 96}
+//	-> {"key": "..."}
+//	{"op": "salt", "scrypt": {"N": 65536, "R": 64, "P": 1}, "password": "..."}
+//	-> {"salt": "..."}
+//	{"op": "purge"}
+//	-> {} or {"error": "..."}
+type request struct {
+	Op        string                     `json:"op"`
+	SCrypt    *crypto.SCryptParameters   `json:"scrypt,omitempty"`
+	Argon2id  *crypto.Argon2idParameters `json:"argon2id,omitempty"`
+	Password  []byte                     `json:"password,omitempty"`
+	Salt      []byte                     `json:"salt,omitempty"`
+	KeyLength int                        `json:"key_length,omitempty"`
+}
+
+type response struct {
+	Key   []byte `json:"key,omitempty"`
+	Salt  []byte `json:"salt,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	maxMessageLength = 64 * 1024
+	maxKeyLength     = 1024
+	requestTimeout   = 10 * time.Second
+)
+
+// DefaultSocket returns the socket path in $XDG_RUNTIME_DIR, or in a directory for the user in the temporary directory
+// (a predictable path: Listen checks the directory belongs to the user)
+func DefaultSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "seof-agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("seof-%v", os.Getuid()), "agent.sock")
+}
+
+// Listen listens in a Unix socket accessible only to the user, creating its directory when missing. The directory must
+// be owned by the user with mode 0700 (or stricter). A stale socket (with no agent listening) is replaced.
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := checkDir(dir); err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("agent: %v exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("agent: already running in %v", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return listenUnix(path)
+}
+
+// Serve serves clients accepted in l deriving keys through cache, until l is closed
+func Serve(l net.Listener, cache *seof.KeyCache) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go serveConn(conn, cache)
+	}
+}
+
+func serveConn(conn net.Conn, cache *seof.KeyCache) {
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	req := request{}
+	if err := json.NewDecoder(io.LimitReader(conn, maxMessageLength)).Decode(&req); err != nil {
+		_ = json.NewEncoder(conn).Encode(response{Error: "agent: invalid request"})
+		return
+	}
+	resp, err := handle(&req, cache)
+	if err != nil {
+		resp.Error = "agent: " + err.Error()
+	}
+	_ = json.NewEncoder(conn).Encode(resp)
+	clear(resp.Key)
+	clear(req.Password)
+}
+
+func handle(req *request, cache *seof.KeyCache) (resp response, err error) {
+	switch req.Op {
+	case "purge":
+		cache.Purge()
+		return
+	case "derive", "salt":
+	default:
+		return resp, fmt.Errorf("unknown operation %q", req.Op)
+	}
+	kdf, err := requestKDF(req)
+	if err != nil {
+		return
+	}
+	if req.Op == "salt" {
+		resp.Salt, err = cache.PasswordSalt(context.Background(), kdf, req.Password)
+		return
+	}
+	if req.KeyLength < 1 || req.KeyLength > maxKeyLength {
+		return resp, errors.New("invalid key length")
+	}
+	resp.Key, err = cache.DeriveKey(context.Background(), kdf, req.Password, req.Salt, req.KeyLength)
+	return
+}
+
+func requestKDF(req *request) (crypto.KDFParameters, error) {
+	var kdf crypto.KDFParameters
+	switch {
+	case req.SCrypt != nil && req.Argon2id == nil:
+		kdf = *req.SCrypt
+	case req.Argon2id != nil && req.SCrypt == nil:
+		kdf = *req.Argon2id
+	default:
+		return nil, errors.New("one key derivation function expected")
+	}
+	if err := kdf.Verify(); err != nil {
+		return nil, err
+	}
+	return kdf, nil
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kuking/seof"
+	"github.com/kuking/seof/crypto"
+)
+
+const password = "this is a long enough password"
+
+func givenAgent(t *testing.T) (*Client, *seof.KeyCache) {
+	socket := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	l, err := Listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	cache := seof.NewKeyCache(time.Minute)
+	go func() { _ = Serve(l, cache) }()
+	return &Client{Socket: socket}, cache
+}
+
+func TestClient_DeriveKey(t *testing.T) {
+	client, cache := givenAgent(t)
+	salt := crypto.RandBytes(96)
+	for _, kdf := range []crypto.KDFParameters{crypto.MinSCryptParameters, crypto.MinArgon2idParameters} {
+		expected, _ := kdf.DeriveKey([]byte(password), salt, 96)
+		for i := 0; i < 2; i++ {
+			key, err := client.DeriveKey(context.Background(), kdf, []byte(password), salt, 96)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(key, expected) {
+				t.Fatal(kdf)
+			}
+		}
+	}
+	if cache.Len() != 2 {
+		t.Fatal(cache.Len())
+	}
+	if err := client.Purge(context.Background()); err != nil || cache.Len() != 0 {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Invalid(t *testing.T) {
+	client, _ := givenAgent(t)
+	for _, kdf := range []crypto.KDFParameters{
+		crypto.SCryptParameters{N: 2, R: 1, P: 1}, // under the minimum
+		crypto.HKDFParameters{},
+	} {
+		if _, err := client.DeriveKey(context.Background(), kdf, []byte(password), crypto.RandBytes(96), 96); err == nil {
+			t.Fatal("should fail:", kdf)
+		}
+	}
+	if _, err := client.DeriveKey(context.Background(), crypto.MinSCryptParameters, []byte(password), nil, 1<<20); err == nil {
+		t.Fatal("key length should be limited")
+	}
+	if _, err := (&Client{Socket: client.Socket + ".missing"}).DeriveKey(context.Background(), crypto.MinSCryptParameters, []byte(password), nil, 96); err == nil {
+		t.Fatal("no agent listening")
+	}
+}
+
+func TestListen(t *testing.T) {
+	client, _ := givenAgent(t)
+	if _, err := Listen(client.Socket); err == nil {
+		t.Fatal("an agent is already running")
+	}
+	info, err := os.Stat(client.Socket)
+	if err != nil || info.Mode().Perm()&0077 != 0 {
+		t.Fatal("the socket should be accessible only to the user", err, info.Mode())
+	}
+
+	// stale socket, the agent is gone
+	private := filepath.Join(t.TempDir(), "private")
+	if err = os.Mkdir(private, 0700); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(private, "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = l.Close()
+	if l, err = Listen(stale); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+}
+
+func TestListen_UnsafeDirectory(t *testing.T) {
+	shared := filepath.Join(t.TempDir(), "shared")
+	if err := os.Mkdir(shared, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(filepath.Join(shared, "agent.sock")); err == nil {
+		t.Fatal("a directory accessible to others should be refused")
+	}
+
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(t.TempDir(), link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(filepath.Join(link, "agent.sock")); err == nil {
+		t.Fatal("a symbolic link should be refused")
+	}
+}
+
+func TestAgent_SetKeyCache(t *testing.T) {
+	client, cache := givenAgent(t)
+	seof.SetKeyCache(client)
+	defer seof.SetKeyCache(nil)
+	name := filepath.Join(t.TempDir(), "file.seof")
+	f, err := seof.CreateExt(name, []byte(password), crypto.MinSCryptParameters, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write([]byte("hello"))
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = seof.OpenExt(name, []byte(password), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if read, _ := io.ReadAll(f); string(read) != "hello" || cache.Len() != 1 {
+		t.Fatal()
+	}
+}
+
+func TestClient_PasswordSalt(t *testing.T) {
+	client, _ := givenAgent(t)
+	salt, err := client.PasswordSalt(context.Background(), crypto.MinSCryptParameters, []byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, _ := client.PasswordSalt(context.Background(), crypto.MinSCryptParameters, []byte(password))
+	other, _ := client.PasswordSalt(context.Background(), crypto.MinArgon2idParameters, []byte(password))
+	if len(salt) != 32 || !bytes.Equal(salt, same) || bytes.Equal(salt, other) {
+		t.Fatal()
+	}
+}
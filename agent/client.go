@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/kuking/seof/crypto"
+)
+
+// Client derives keys with the agent listening in Socket, it can be given to seof.SetKeyCache
+type Client struct {
+	Socket string
+}
+
+func (c *Client) DeriveKey(ctx context.Context, kdf crypto.KDFParameters, password []byte, salt []byte, keyLen int) ([]byte, error) {
+	req := request{Op: "derive", Password: password, Salt: salt, KeyLength: keyLen}
+	if err := setKDF(&req, kdf); err != nil {
+		return nil, err
+	}
+	resp, err := c.call(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Key) != keyLen {
+		return nil, errors.New("agent: invalid key length")
+	}
+	return resp.Key, nil
+}
+
+// PasswordSalt returns the agent's password salt for new files with the password, see seof.PasswordSalter
+func (c *Client) PasswordSalt(ctx context.Context, kdf crypto.KDFParameters, password []byte) ([]byte, error) {
+	req := request{Op: "salt", Password: password}
+	if err := setKDF(&req, kdf); err != nil {
+		return nil, err
+	}
+	resp, err := c.call(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Salt) == 0 {
+		return nil, errors.New("agent: no password salt")
+	}
+	return resp.Salt, nil
+}
+
+func setKDF(req *request, kdf crypto.KDFParameters) error {
+	switch params := kdf.(type) {
+	case crypto.SCryptParameters:
+		req.SCrypt = &params
+	case crypto.Argon2idParameters:
+		req.Argon2id = &params
+	default:
+		return fmt.Errorf("agent: unsupported key derivation function %T", kdf)
+	}
+	return nil
+}
+
+// Purge wipes the keys held by the agent
+func (c *Client) Purge(ctx context.Context) error {
+	_, err := c.call(ctx, &request{Op: "purge"})
+	return err
+}
+
+func (c *Client) call(ctx context.Context, req *request) (*response, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", c.Socket)
+	if err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	resp := response{}
+	if err = json.NewDecoder(io.LimitReader(conn, maxMessageLength)).Decode(&resp); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// ctxErr returns ctx.Err() when the connection failed for being closed on ctx done
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("agent: %w", err)
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// checkDir fails unless dir is a directory, not a symbolic link; owners and modes are not checked in this platform
+func checkDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("agent: %v is not a directory", dir)
+	}
+	return nil
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,40 @@
+//go:build unix
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkDir fails unless dir is a directory (not a symbolic link) owned by the user and inaccessible to others, as a
+// predictable path in the temporary directory could have been created by someone else
+func checkDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok {
+		return fmt.Errorf("agent: %v is not a directory", dir)
+	}
+	if int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("agent: %v is not owned by the user", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("agent: %v is accessible to other users (mode %v), it should be 0700", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+// listenUnix creates the socket with a 0077 umask, so it is never accessible to others. The umask is process wide,
+// files created meanwhile by other goroutines get it too.
+func listenUnix(path string) (net.Listener, error) {
+	old := unix.Umask(0077)
+	defer unix.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -1,55 +1,114 @@
 package seof
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"sync"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/klauspost/reedsolomon"
 	"github.com/kuking/seof/crypto"
-	"golang.org/x/crypto/scrypt"
 )
 
 const nonceSize int = 36
 
 type File struct {
-	mutex      sync.Mutex
-	file       *os.File
-	pendingErr *error
-	header     Header
-	blockZero  BlockZero
-	aead       [3]cipher.AEAD
-	cache      *lru.Cache
-	cursor     int64
+	mutex       sync.Mutex
+	file        *os.File
+	pendingErr  *error
+	header      Header
+	ext         HeaderExt
+	dataOffset  int64 // where block zero starts, after the header and its extensions
+	blockZero   BlockZero
+	aead        [3]cipher.AEAD
+	cache       blockCache
+	cursor      int64
+	rs          reedsolomon.Encoder
+	staleGroups map[int64]bool // parity groups to be recalculated
+	attrs       map[string]string
+	buffers     int         // cache size, memory buffers (0 with a shared cache)
+	secure      *securePool // cached blocks memory, see UseSecureMemory
+
+	encryptedCache bool           // see UseEncryptedCache
+	openedBlock    *inMemoryBlock // the cached block decrypted, in use
+
+	stats fileStats // see Stats and SetHooks
+
+	flag       int // os.OpenFile flag
+	log        *slog.Logger
+	rand       io.Reader
+	sparse     SparsePolicy
+	durability Durability
+
+	writePolicy    WritePolicy
+	maxDirtyAge    time.Duration
+	maxDirtyBlocks int
+	dirty          map[int64]time.Time // modified blocks, since when
+	flushedSize    uint64              // file size in block zero on disk
+	attrsModified  bool                // attributes changed since block zero was written
+	flushedBlocks  uint64              // blocks written count when block zero was last written
+	flusher        *flusher            // see WithWriteBack
 }
 
 type inMemoryBlock struct {
 	modified  bool
 	plainText []byte
+	secure    bool   // plainText is in secure memory
+	sealed    bool   // plainText is encrypted, see UseEncryptedCache
+	nonce     uint64 // when sealed
 }
 
 func (i *inMemoryBlock) Reset() {
 	i.modified = false
-	for x := 0; x < len(i.plainText); x++ {
-		i.plainText[x] = 0 // maybe use MemGuard.Wipe()
+	wipe(i.plainText[:cap(i.plainText)])
+}
+
+// initialiseCiphers derives the key, checking it against the key check value when the file has one
+func (f *File) initialiseCiphers(ctx context.Context, password []byte, header *Header) error {
+	key, err := f.deriveKey(ctx, password, header)
+	if err != nil {
+		return err
+	}
+	defer wipe(key)
+	if f.ext.KeyCheck != ([keyCheckLength]byte{}) && !hmac.Equal(f.ext.KeyCheck[:], f.keyCheck(key, header)) {
+		return ErrWrongPassword
 	}
+	return f.initialiseCiphersWithKey(key)
 }
-func (f *File) initialiseCiphers(password []byte, header *Header) error {
+
+func (f *File) deriveKey(ctx context.Context, password []byte, header *Header) ([]byte, error) {
 	err := header.Verify()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var key []byte
-	key, err = scrypt.Key(password, header.ScriptSalt[:], int(header.ScriptN), int(header.ScriptR), int(header.ScriptP), 96)
+	kdf, err := f.kdfParameters(header)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	start := time.Now()
+	var key []byte
+	if f.ext.PasswordSalt != ([passwordSaltLength]byte{}) {
+		key, err = derivePasswordKey(ctx, kdf, password, f.ext.PasswordSalt[:], header.ScriptSalt[:], 96)
+	} else {
+		key, err = deriveKey(ctx, kdf, password, header.ScriptSalt[:], 96)
+	}
+	if err == nil {
+		f.observe(KeyDerived, 1, time.Since(start))
 	}
+	return key, err
+}
+
+func (f *File) initialiseCiphersWithKey(key []byte) error {
+	var err error
 	var block cipher.Block
 	keySize := 32
 	for i := 0; i < 3; i++ {
@@ -65,29 +124,88 @@ func (f *File) initialiseCiphers(password []byte, header *Header) error {
 	return nil
 }
 
-func (f *File) initialiseCache(size int) error {
-	var err error
-	f.cache, err = lru.NewWithEvict(size, f.flushBlock)
-	return err
+// kdfParameters returns the key derivation parameters, in the header extension when not scrypt
+func (f *File) kdfParameters(header *Header) (crypto.KDFParameters, error) {
+	scryptParams := crypto.SCryptParameters{N: header.ScriptN, R: header.ScriptR, P: header.ScriptP}
+	if f.ext.KDF != KDFSCrypt && scryptParams != (crypto.SCryptParameters{}) {
+		return nil, &ErrInvalidHeader{Field: "scrypt_parameters"}
+	}
+	switch f.ext.KDF {
+	case KDFArgon2id:
+		return f.ext.Argon2id, nil
+	case KDFRawKey:
+		return crypto.HKDFParameters{KeyID: f.ext.KeyID}, nil
+	}
+	if err := scryptParams.Verify(); err != nil {
+		return nil, &ErrInvalidHeader{Field: "scrypt_parameters"}
+	}
+	return scryptParams, nil
+}
+
+func (f *File) initialiseCache(o *Options) error {
+	if o.SharedCache != nil {
+		f.cache = o.SharedCache.view(f)
+		return nil
+	}
+	cache, err := lru.NewWithEvict(o.CacheSize, f.evictBlock)
+	if err != nil {
+		return err
+	}
+	f.buffers, f.cache = o.CacheSize, cache
+	return nil
+}
+
+// newInMemoryBlock returns a block holding plainText (or an empty one when nil), moved into secure memory when enabled
+func (f *File) newInMemoryBlock(plainText []byte) *inMemoryBlock {
+	if f.secure != nil && len(plainText) <= f.secure.size {
+		if buf := f.secure.get(); buf != nil {
+			buf = append(buf, plainText...)
+			wipe(plainText)
+			return &inMemoryBlock{plainText: buf, secure: true}
+		}
+	}
+	if plainText == nil {
+		plainText = make([]byte, 0, f.blockZero.BEncBlockSize)
+	}
+	return &inMemoryBlock{plainText: plainText}
+}
+
+// evictBlock flushes a block leaving the cache, and wipes it
+func (f *File) evictBlock(blockI interface{}, dataI interface{}) {
+	imb := dataI.(*inMemoryBlock)
+	f.flushBlock(blockI, imb)
+	imb.Reset()
+	if imb.secure && f.secure != nil {
+		f.secure.put(imb.plainText)
+	}
+	imb.plainText, imb.secure = nil, false
 }
 
 func (f *File) flushBlock(blockI interface{}, dataI interface{}) {
 	blockNo := blockI.(int64)
 	imb := dataI.(*inMemoryBlock)
-	defer imb.Reset()
+	defer func() { imb.modified = false }()
+	delete(f.dirty, blockNo)
 	if !imb.modified {
 		return
 	}
+	if imb.sealed {
+		xorCachedBlock(imb)
+		imb.sealed = false
+		defer sealCachedBlock(imb)
+	}
 
-	blockOffset := int64(HeaderLength) + int64(f.blockZero.DiskBlockSize)*blockNo
+	fail := func(err error) {
+		f.setPendingErr(fmt.Errorf("writing block %v: %w", blockNo, err))
+	}
+	blockOffset := f.blockOffset(blockNo)
 	newOfs, err := f.file.Seek(blockOffset, 0)
-	if newOfs != blockOffset {
-		err := errors.New("failed to fseek")
-		f.pendingErr = &err
+	if err != nil {
+		fail(err)
 		return
 	}
-	if err != nil {
-		f.pendingErr = &err
+	if newOfs != blockOffset {
+		fail(errors.New("failed to fseek"))
 		return
 	}
 
@@ -95,52 +213,120 @@ func (f *File) flushBlock(blockI interface{}, dataI interface{}) {
 		panic(fmt.Sprintf("block %v plainText too big: %v > %v\n", blockNo, len(imb.plainText), int(f.blockZero.BEncBlockSize)))
 	}
 
-	cipherText, nonce := f.seal(imb.plainText, uint64(blockNo))
+	cipherText, nonce, err := f.seal(imb.plainText, uint64(blockNo))
+	if err != nil {
+		fail(err)
+		return
+	}
 	if len(cipherText) > int(f.blockZero.DiskBlockSize) {
 		panic(fmt.Sprintf("cipherText encoded size too big: %v > %v\n", len(cipherText), f.blockZero.DiskBlockSize))
 	}
 	n, err := f.file.Write(nonce)
 	if err != nil {
-		f.pendingErr = &err
+		fail(err)
 		return
 	}
 	if n != len(nonce) {
-		err := errors.New("could not write fully to disk")
-		f.pendingErr = &err
+		fail(io.ErrShortWrite)
 		return
 	}
 	err = binary.Write(f.file, binary.LittleEndian, uint32(len(cipherText)))
 	if err != nil {
-		f.pendingErr = &err
+		fail(err)
 		return
 	}
 	n, err = f.file.Write(cipherText)
-	if n != len(cipherText) {
-		err := errors.New("could not write fully to disk")
-		f.pendingErr = &err
+	if err != nil {
+		fail(err)
 		return
 	}
-	if err != nil {
-		f.pendingErr = &err
+	if n != len(cipherText) {
+		fail(io.ErrShortWrite)
 		return
 	}
 	f.blockZero.BlocksWritten++
+	f.markParityStale(blockNo)
+	f.observe(DirtyFlush, 1, 0)
 }
 
 func (f *File) flushBlockZero() {
-	f.flushBlock(int64(0), &inMemoryBlock{
+	imb := inMemoryBlock{
 		modified:  true,
-		plainText: f.blockZero.Bytes(),
-	})
+		plainText: f.blockZeroBytes(),
+	}
+	f.flushBlock(int64(0), &imb)
+	imb.Reset()
+	f.flushedSize = f.blockZero.BEncFileSize
+	f.attrsModified = false
+	f.flushedBlocks = f.blockZero.BlocksWritten
+}
+
+// blockZeroModified tells if block zero changed since written: the size, attributes or blocks written count
+func (f *File) blockZeroModified() bool {
+	return f.blockZero.BEncFileSize != f.flushedSize || f.attrsModified || f.blockZero.BlocksWritten != f.flushedBlocks
 }
 
 func (f *File) getOrLoadBlock(blockNo int64) (*inMemoryBlock, error) {
 
 	if imb, ok := f.cache.Get(blockNo); ok {
+		f.observe(CacheHit, 1, 0)
+		f.openBlock(imb.(*inMemoryBlock))
 		return imb.(*inMemoryBlock), nil
 	}
+	if f.rs != nil && blockNo > f.lastBlockNo() {
+		// with parity, disk space past the last block might be allocated (parity blocks follow their group)
+		return nil, io.EOF
+	}
+
+	f.observe(CacheMiss, 1, 0)
+	plainText, err := f.loadBlock(blockNo)
+	if err != nil && f.rs != nil {
+		plainText, err = f.repairBlock(blockNo, err)
+	}
+	if err != nil && f.isHole(blockNo) {
+		plainText, err = make([]byte, f.blockZero.BEncBlockSize), nil
+	}
+	if err != nil {
+		var corrupt *ErrCorruptBlock
+		if errors.As(err, &corrupt) {
+			f.logger().Warn("corrupt block", "block", blockNo, "err", err)
+		}
+		return nil, err
+	}
+	imb := f.newInMemoryBlock(plainText)
+
+	f.addToCache(blockNo, imb)
+	f.openBlock(imb)
+
+	return imb, nil
+}
+
+// isHole tells if the block was never written (a hole, read as zeros), only with SparseZeros
+func (f *File) isHole(blockNo int64) bool {
+	if f.sparse != SparseZeros || blockNo == 0 || blockNo >= f.lastBlockNo() {
+		return false
+	}
+	slot, err := f.readSlot(f.blockOffset(blockNo))
+	return err == nil && isZeroed(slot)
+}
 
-	blockOffset := int64(HeaderLength) + int64(f.blockZero.DiskBlockSize)*blockNo
+func (f *File) addToCache(blockNo int64, imb *inMemoryBlock) {
+	if f.cache.Add(blockNo, imb) {
+		f.observe(Eviction, 1, 0)
+	}
+}
+
+func (f *File) loadBlock(blockNo int64) ([]byte, error) {
+	offset := f.blockOffset(blockNo)
+	plainText, err := f.loadBlockAt(offset, f.additionalData(uint64(blockNo), false))
+	if err != nil && err != io.EOF {
+		return nil, &ErrCorruptBlock{BlockNo: blockNo, Offset: offset, Err: err}
+	}
+	return plainText, err
+}
+
+// loadBlockAt reads and decrypts the block at the disk offset, authenticating the additional data
+func (f *File) loadBlockAt(blockOffset int64, additional []byte) ([]byte, error) {
 	seekOfs, err := f.file.Seek(blockOffset, 0)
 	if err != nil {
 		return nil, err
@@ -155,7 +341,7 @@ func (f *File) getOrLoadBlock(blockNo int64) (*inMemoryBlock, error) {
 		return nil, err
 	}
 	if n != nonceSize {
-		return nil, errors.New("could not read nonce bytes")
+		return nil, ErrTruncated
 	}
 	if err != nil {
 		return nil, err
@@ -163,97 +349,154 @@ func (f *File) getOrLoadBlock(blockNo int64) (*inMemoryBlock, error) {
 	var cipherTextLen uint32
 	err = binary.Read(f.file, binary.LittleEndian, &cipherTextLen)
 	if err != nil {
-		return nil, err
+		return nil, truncatedError(err)
+	}
+	if int64(cipherTextLen) > int64(f.blockZero.DiskBlockSize)-int64(nonceSize)-4 {
+		return nil, errors.New("invalid cipherText length")
 	}
 
 	cipherText := make([]byte, cipherTextLen)
-	n, err = f.file.Read(cipherText)
-	if n != int(cipherTextLen) {
-		return nil, errors.New("could not read cipherText from file")
-	}
+	n, err = io.ReadFull(f.file, cipherText)
 	if err != nil {
-		return nil, err
+		return nil, truncatedError(err)
 	}
 
-	plainText, err := f.unseal(cipherText, uint64(blockNo), nonce)
-	if err != nil {
-		return nil, err
-	}
-	imb := inMemoryBlock{
-		modified:  false,
-		plainText: plainText,
-	}
-
-	f.cache.Add(blockNo, &imb)
+	return f.unsealAD(cipherText, additional, nonce)
+}
 
-	return &imb, nil
+func (f *File) seal(plainText []byte, blockNo uint64) (cipherText []byte, nonce []byte, err error) {
+	return f.sealAD(plainText, f.additionalData(blockNo, false))
 }
 
-func (f *File) seal(plainText []byte, blockNo uint64) (cipherText []byte, nonce []byte) {
-	additional := make([]byte, 8)
-	binary.LittleEndian.PutUint64(additional, blockNo)
+// sealAD encrypts a block with a random nonce, failing only when the Rand option fails
+func (f *File) sealAD(plainText []byte, additional []byte) (cipherText []byte, nonce []byte, err error) {
 	if f.aead[0].NonceSize()*3 != nonceSize {
 		panic("unexpected nonce size")
 	}
-	nonce = crypto.RandBytes(nonceSize)
+	start := time.Now()
+	if nonce, err = f.randBytes(nonceSize); err != nil {
+		return nil, nil, err
+	}
 	cipherText = f.aead[0].Seal(nil, nonce[0:12], plainText, additional)
 	cipherText = f.aead[1].Seal(nil, nonce[12:24], cipherText, additional)
 	cipherText = f.aead[2].Seal(nil, nonce[24:36], cipherText, additional)
+	f.observe(BlockSealed, 1, time.Since(start))
 	return
 }
 
 func (f *File) unseal(cipherText []byte, blockNo uint64, nonce []byte) (plainText []byte, err error) {
-	additional := make([]byte, 8)
-	binary.LittleEndian.PutUint64(additional, blockNo)
+	return f.unsealAD(cipherText, f.additionalData(blockNo, false), nonce)
+}
+
+func (f *File) unsealAD(cipherText []byte, additional []byte, nonce []byte) (plainText []byte, err error) {
+	start := time.Now()
+	defer func() { f.observe(BlockUnsealed, 1, time.Since(start)) }()
 	// 3
 	cipherText, err = f.aead[2].Open(nil, nonce[24:36], cipherText, additional)
 	if err != nil {
-		return
+		return nil, errNotAuthentic
 	}
 	cipherText, err = f.aead[1].Open(nil, nonce[12:24], cipherText, additional)
 	if err != nil {
-		return
+		return nil, errNotAuthentic
 	}
 	plainText, err = f.aead[0].Open(nil, nonce[0:12], cipherText, additional)
+	if err != nil {
+		return nil, errNotAuthentic
+	}
 	return
 }
 
-func Create(_ string) (*File, error) {
-	return nil, errors.New("use CreateExt")
+// additionalData returns the AEAD additional data for a block: its number, followed in streams by the last block flag
+// (only ever set with NewArchiveWriter), so a stream stripped of its extension record fails to authenticate. Block
+// zero's is followed by the key check value, when the file has one: it can not be stripped from the header (it
+// authenticates the header) without block zero failing to authenticate.
+func (f *File) additionalData(blockNo uint64, last bool) []byte {
+	additional := make([]byte, 8, 9)
+	binary.LittleEndian.PutUint64(additional, blockNo)
+	if f.ext.Stream {
+		flag := byte(0)
+		if last {
+			flag = 1
+		}
+		additional = append(additional, flag)
+	}
+	if blockNo == 0 && f.ext.KeyCheck != ([keyCheckLength]byte{}) {
+		additional = append(additional, f.ext.KeyCheck[:]...)
+	}
+	return additional
+}
+
+func OpenExt(name string, password []byte, memoryBuffers int) (*File, error) {
+	return Open(name, WithPassword(password), WithCacheSize(memoryBuffers))
 }
 
-func Open(_ string) (*File, error) {
-	return nil, errors.New("use OpenExt")
+// OpenExtContext opens a file like OpenExt, returning ctx.Err() when ctx is done while deriving the key (see
+// crypto.DeriveKeyContext).
+func OpenExtContext(ctx context.Context, name string, password []byte, memoryBuffers int) (*File, error) {
+	return Open(name, WithContext(ctx), WithPassword(password), WithCacheSize(memoryBuffers))
 }
 
-func OpenFile(_ string, _ int, _ os.FileMode) (*File, error) {
-	return nil, errors.New("use OpenExt")
+// OpenWithKey opens a file created with CreateWithKey, no password based key derivation is done.
+func OpenWithKey(name string, key []byte, memoryBuffers int) (*File, error) {
+	return Open(name, WithKey(key), WithCacheSize(memoryBuffers))
 }
 
-func OpenExt(name string, password []byte, memoryBuffers int) (*File, error) {
-	if memoryBuffers < 1 || memoryBuffers > 1024 {
-		return nil, errors.New("memory buffers can be between 1 and 1024")
+func fixedSecret(secret []byte) func(ext *HeaderExt) ([]byte, error) {
+	return func(_ *HeaderExt) ([]byte, error) {
+		return secret, nil
 	}
+}
 
-	var err error
-	file := File{}
-	header := Header{}
-	file.file, err = os.Open(name)
+// open opens a password or raw key based file, secretFor returns the password or key given the header extension
+func open(name string, flag int, o *Options, rawKey bool, secretFor func(ext *HeaderExt) ([]byte, error)) (_ *File, err error) {
+	file := File{flag: flag}
+	file.mutex.Lock() // blocks are cached while opening, so a shared cache does not evict them meanwhile
+	defer file.mutex.Unlock()
+	file.applyOptions(o)
+	file.file, err = os.OpenFile(name, flag, 0)
 	if err != nil {
 		return nil, err
 	}
-	err = binary.Read(file.file, binary.LittleEndian, &header)
+	defer func() {
+		if err != nil { // block zero might be in a shared cache
+			if file.cache != nil {
+				file.cache.Purge()
+			}
+			_ = file.file.Close()
+		}
+	}()
+	err = file.readHeaders(file.file)
+	if err != nil {
+		return nil, err
+	}
+	header := file.header
+	file.dataOffset, err = file.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if err = file.checkKeyType(rawKey); err != nil {
+		return nil, err
+	}
+	if file.ext.Stream && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil, errors.New("stream files are read only")
+	}
+	secret, err := secretFor(&file.ext)
+	if err != nil {
+		return nil, err
+	}
+
+	err = file.initialiseCiphers(o.Context, secret, &header)
 	if err != nil {
 		return nil, err
 	}
-	file.header = header
 
-	err = file.initialiseCiphers(password, &header)
+	err = file.initialiseCache(o)
 	if err != nil {
 		return nil, err
 	}
 
-	err = file.initialiseCache(memoryBuffers)
+	err = file.initialiseParity()
 	if err != nil {
 		return nil, err
 	}
@@ -266,58 +509,228 @@ func OpenExt(name string, password []byte, memoryBuffers int) (*File, error) {
 	}
 	imb, err := file.getOrLoadBlock(0) //FIXME: blockZero should not be cached
 	if err != nil {
-		return nil, err
+		return nil, file.blockZeroError(err)
+	}
+	plainText := imb.plainText
+	if file.ext.Stream {
+		plainText, err = file.loadTrailer()
+		if err != nil {
+			return nil, err
+		}
 	}
-	bz, err := BlockZeroFromBytes(imb.plainText)
+	bz, err := BlockZeroFromBytes(plainText)
 	if err != nil {
 		return nil, err
 	}
 	file.blockZero = *bz
+	file.flushedSize = bz.BEncFileSize
+	file.flushedBlocks = bz.BlocksWritten
+	file.attrs, err = attrsFromBlockZeroBytes(plainText)
+	if err != nil {
+		return nil, err
+	}
+	file.startFlusher()
 
 	return &file, nil
 }
 
+// readHeaders reads the header and its extension
+func (f *File) readHeaders(r io.Reader) error {
+	header := Header{}
+	err := binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return truncatedError(err)
+	}
+	f.header = header
+	if header.Magic == HeaderMagicExt {
+		ext, err := HeaderExtFromReader(r)
+		if err != nil {
+			return truncatedError(err)
+		}
+		if err = ext.Verify(); err != nil {
+			return err
+		}
+		f.ext = *ext
+	}
+	return nil
+}
+
+// checkKeyType fails when the file is not opened with its kind of secret (a password or a raw key)
+func (f *File) checkKeyType(rawKey bool) error {
+	if rawKey && f.ext.KDF != KDFRawKey {
+		return errors.New("file is password based, use OpenExt")
+	}
+	if !rawKey && f.ext.KDF == KDFRawKey {
+		return errors.New("file is key based, use OpenWithKey")
+	}
+	return nil
+}
+
+// blockZeroError tells a truncated file, and in files without a key check value, a wrong password (block zero does not
+// authenticate)
+func (f *File) blockZeroError(err error) error {
+	if err == io.EOF || errors.Is(err, ErrTruncated) {
+		return ErrTruncated
+	}
+	if errors.Is(err, errNotAuthentic) && f.ext.KeyCheck == ([keyCheckLength]byte{}) {
+		return ErrWrongPassword
+	}
+	return err
+}
+
 func CreateExt(name string, password []byte, scryptParams crypto.SCryptParameters, BEBlockSize int, memoryBuffers int) (*File, error) {
+	return Create(name, WithPassword(password), WithKDF(scryptParams), WithBlockSize(BEBlockSize), WithCacheSize(memoryBuffers))
+}
+
+// CreateExtParity creates a file like CreateExt, adding parity.Parity Reed-Solomon parity blocks for every parity.Data
+// blocks. A block failing to decrypt is reconstructed with its group's parity, and rewritten. NoParity is accepted.
+// The key derivation function is given by its parameters type, crypto.SCryptParameters or crypto.Argon2idParameters.
+func CreateExtParity(name string, password []byte, kdf crypto.KDFParameters, parity ParityParameters, BEBlockSize int, memoryBuffers int) (*File, error) {
+	return CreateExtContext(context.Background(), name, password, kdf, parity, BEBlockSize, memoryBuffers)
+}
+
+// CreateExtContext creates a file like CreateExtParity, returning ctx.Err() when ctx is done while deriving the key
+// (see crypto.DeriveKeyContext).
+func CreateExtContext(ctx context.Context, name string, password []byte, kdf crypto.KDFParameters, parity ParityParameters, BEBlockSize int, memoryBuffers int) (*File, error) {
+	return Create(name, WithContext(ctx), WithPassword(password), WithKDF(kdf), WithParity(parity),
+		WithBlockSize(BEBlockSize), WithCacheSize(memoryBuffers))
+}
+
+// CreateWithKey creates a file encrypted with raw key material (at least crypto.MinRawKeyLength bytes, i.e. a random
+// key kept in a KMS), the ciphers keys are derived with HKDF and the file salt. It avoids the password based key
+// derivation cost, the file is marked as key based and can only be opened with OpenWithKey.
+func CreateWithKey(name string, key []byte, parity ParityParameters, BEBlockSize int, memoryBuffers int) (*File, error) {
+	return Create(name, WithKey(key), WithParity(parity), WithBlockSize(BEBlockSize), WithCacheSize(memoryBuffers))
+}
+
+// create creates a file with the options credentials: with a key provider, a new data key is wrapped and stored
+func create(name string, flag int, perm os.FileMode, o *Options) (*File, error) {
+	secret, wrappedKey := o.Password, []byte(nil)
+	if o.Key != nil {
+		secret = o.Key
+	}
+	if o.KeyProvider != nil {
+		dek, err := randBytes(o.Rand, dataKeyLength)
+		if err != nil {
+			return nil, err
+		}
+		defer wipe(dek)
+		wrapped, err := o.KeyProvider.WrapKey(o.Context, dek)
+		if err != nil {
+			return nil, err
+		}
+		if len(wrapped) == 0 || len(wrapped) > maxWrappedKeyLength {
+			return nil, errors.New("wrapped key length should be between 1 and 8KB")
+		}
+		secret, wrappedKey = dek, wrapped
+	}
+	ext := HeaderExt{
+		ParityData:   o.Parity.Data,
+		ParityShards: o.Parity.Parity,
+		WrappedKey:   string(wrappedKey),
+	}
+	file, err := newFile(o, secret, ext)
+	if err != nil {
+		return nil, err
+	}
+	file.mutex.Lock() // see open
+	defer file.mutex.Unlock()
+	file.flag = flag
+
+	err = file.initialiseCache(o)
+	if err != nil {
+		return nil, err
+	}
+
+	err = file.initialiseParity()
+	if err != nil {
+		return nil, err
+	}
+
+	// writes common headers
+	file.file, err = os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	err = file.writeHeaders(file.file)
+	if err != nil { // possible left open file
+		return nil, err
+	}
+
+	file.flushBlockZero()
+	file.startFlusher()
+
+	return file, nil
+}
+
+// newFile initialises the header, extension, ciphers and block zero of a new file with the options key derivation
+// function and block size, ext has the non key derivation extensions already set
+func newFile(o *Options, password []byte, ext HeaderExt) (*File, error) {
 	if len(password) < 12 {
 		return nil, errors.New("password should be at least 12 characters long")
 	}
+	BEBlockSize := o.BlockSize
 	if BEBlockSize < 1024 || BEBlockSize > 128*1024 {
 		return nil, errors.New("before encryption block size has to be between 1KB and 128KB")
 	}
-	if memoryBuffers < 1 || memoryBuffers > 128 {
-		return nil, errors.New("memory buffers can be between 1 and 128")
-	}
 
 	var err error
-	file := File{}
+	file := File{ext: ext}
+	file.applyOptions(o)
+	copy(file.ext.PasswordSalt[:], o.PasswordSalt)
 
 	// header
 	header := Header{
 		Magic:         HeaderMagic,
 		ScriptSalt:    [96]byte{},
-		ScriptN:       scryptParams.N,
-		ScriptR:       scryptParams.R,
-		ScriptP:       scryptParams.P,
 		DiskBlockSize: 0,
 		TailOfZeros:   [8]byte{},
 	}
-	copy(header.ScriptSalt[:], crypto.RandBytes(len(header.ScriptSalt)))
+	salt, err := file.randBytes(len(header.ScriptSalt))
+	if err != nil {
+		return nil, err
+	}
+	copy(header.ScriptSalt[:], salt)
 	header.DiskBlockSize = 2000 // temporarily fixed for initialising ciphers
+	switch params := o.KDF.(type) {
+	case crypto.SCryptParameters:
+		header.ScriptN, header.ScriptR, header.ScriptP = params.N, params.R, params.P
+	case crypto.Argon2idParameters:
+		file.ext.KDF = KDFArgon2id
+		file.ext.Argon2id = params
+	case crypto.HKDFParameters:
+		file.ext.KDF = KDFRawKey
+		file.ext.KeyID = params.KeyID
+	default:
+		return nil, errors.New("unsupported key derivation function")
+	}
+	if !file.ext.IsEmpty() || o.KeyCheck {
+		header.Magic = HeaderMagicExt
+		if err = file.ext.Verify(); err != nil {
+			return nil, err
+		}
+	}
 
-	err = file.initialiseCiphers(password, &header)
+	key, err := file.deriveKey(o.Context, password, &header)
 	if err != nil {
 		return nil, err
 	}
-
-	err = file.initialiseCache(memoryBuffers)
+	defer wipe(key)
+	err = file.initialiseCiphersWithKey(key)
 	if err != nil {
 		return nil, err
 	}
 
 	// calculates encrypted block size
 	plainTextBlock := crypto.RandBytes(BEBlockSize)
-	cipherText, _ := file.seal(plainTextBlock, 1)
+	cipherText, _, err := file.seal(plainTextBlock, 1)
+	if err != nil {
+		return nil, err
+	}
 	header.DiskBlockSize = uint32(nonceSize + 4 + len(cipherText)) // 4=length of uint32 for cipherTextLength
+	if o.KeyCheck {
+		copy(file.ext.KeyCheck[:], file.keyCheck(key, &header))
+	}
 	file.header = header
 
 	// blockZero
@@ -327,24 +740,25 @@ func CreateExt(name string, password []byte, scryptParams crypto.SCryptParameter
 		BEncFileSize:  0,
 		BlocksWritten: 1,
 	}
+	return &file, nil
+}
 
-	// writes common headers
-	file.file, err = os.Create(name)
+// writeHeaders writes the header and its extension, setting where block zero starts
+func (f *File) writeHeaders(w io.Writer) error {
+	err := binary.Write(w, binary.LittleEndian, &f.header)
 	if err != nil {
-		return nil, err
-	}
-	err = binary.Write(file.file, binary.LittleEndian, &header)
-	if err != nil { // possible left open file
-		return nil, err
+		return err
 	}
-
-	imb := inMemoryBlock{
-		modified:  true,
-		plainText: file.blockZero.Bytes(),
+	f.dataOffset = int64(HeaderLength)
+	if f.header.Magic == HeaderMagicExt {
+		extBytes := f.ext.Bytes()
+		_, err = w.Write(extBytes)
+		if err != nil {
+			return err
+		}
+		f.dataOffset += int64(len(extBytes))
 	}
-	file.flushBlock(int64(0), &imb)
-
-	return &file, nil
+	return nil
 }
 
 func (f *File) blockNoForOffset(offset int64) int64 {
@@ -358,33 +772,43 @@ func (f *File) blockNoForCursor() int64 {
 func (f *File) Write(b []byte) (n int, err error) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
-	return f.writeLocked(b)
+	defer f.resealCache()
+	n, err = f.writeLocked(b)
+	f.observe(BytesWritten, n, 0)
+	f.wrote()
+	return n, err
 }
 
 func (f *File) writeLocked(b []byte) (n int, err error) {
 	if f.pendingErr != nil {
 		return 0, *f.pendingErr
 	}
+	if err = f.checkWritable("write"); err != nil {
+		return 0, err
+	}
 	if len(b) == 0 {
 		return 0, nil
 	}
+	if f.sparse == SparseFill && uint64(f.cursor) > f.blockZero.BEncFileSize {
+		if err = f.fillLocked(f.cursor); err != nil {
+			return 0, err
+		}
+	}
 	blockNo := f.blockNoForCursor()
 	var imb *inMemoryBlock
 	imb, err = f.getOrLoadBlock(blockNo)
 
 	if err != nil && err == io.EOF {
 		// at the tail of the file, a new block is created
-		newImb := inMemoryBlock{
-			modified:  false,
-			plainText: make([]byte, 0, f.blockZero.BEncBlockSize),
-		}
-		imb = &newImb
-		f.cache.Add(blockNo, imb)
+		imb = f.newInMemoryBlock(nil)
+		f.addToCache(blockNo, imb)
+		f.openBlock(imb)
 	} else if err != nil {
 		return 0, err
 	}
 
 	imb.modified = true
+	f.markDirty(blockNo)
 	// appends zeroes if not intend to write at the beginning of the block, and the block is empty
 	ofsStart := int(f.cursor % int64(f.blockZero.BEncBlockSize))
 	for i := len(imb.plainText); i < ofsStart; i++ {
@@ -416,14 +840,31 @@ func (f *File) writeLocked(b []byte) (n int, err error) {
 	}
 }
 
+// fillLocked writes zeros from the end of the file up to end, leaving the cursor there
+func (f *File) fillLocked(end int64) error {
+	zeros := make([]byte, f.blockZero.BEncBlockSize)
+	f.cursor = int64(f.blockZero.BEncFileSize)
+	for f.cursor < end {
+		n := min(end-f.cursor, int64(len(zeros)))
+		if _, err := f.writeLocked(zeros[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
+	defer f.resealCache()
 	_, err = f.seekLocked(off, 0)
 	if err != nil {
 		return
 	}
-	return f.writeLocked(b)
+	n, err = f.writeLocked(b)
+	f.observe(BytesWritten, n, 0)
+	f.wrote()
+	return n, err
 }
 
 func (f *File) WriteString(s string) (n int, err error) {
@@ -433,7 +874,10 @@ func (f *File) WriteString(s string) (n int, err error) {
 func (f *File) Read(b []byte) (n int, err error) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
-	return f.readLocked(b)
+	defer f.resealCache()
+	n, err = f.readLocked(b)
+	f.observe(BytesRead, n, 0)
+	return n, err
 }
 
 func (f *File) readLocked(b []byte) (n int, err error) {
@@ -446,6 +890,10 @@ func (f *File) readLocked(b []byte) (n int, err error) {
 	if f.cursor >= int64(f.blockZero.BEncFileSize) {
 		return 0, io.EOF
 	}
+	if remaining := int64(f.blockZero.BEncFileSize) - f.cursor; int64(len(b)) > remaining {
+		// the last block might hold appends not yet in block zero (WriteThrough), they are not read
+		b = b[:remaining]
+	}
 	blockNo := f.blockNoForCursor()
 	imb, err := f.getOrLoadBlock(blockNo)
 	if err != nil {
@@ -487,11 +935,14 @@ func (f *File) readLocked(b []byte) (n int, err error) {
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
+	defer f.resealCache()
 	_, err = f.seekLocked(off, 0)
 	if err != nil {
 		return
 	}
-	return f.readLocked(b)
+	n, err = f.readLocked(b)
+	f.observe(BytesRead, n, 0)
+	return n, err
 }
 
 //func (f *File) ReadFrom(r io.Reader) (n int64, err error) {
@@ -531,22 +982,34 @@ func (f *File) seekLocked(offset int64, whence int) (ret int64, err error) {
 func (f *File) Sync() error {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
-	for _, blockNoI := range f.cache.Keys() {
-		imbI, ok := f.cache.Get(blockNoI)
-		if ok {
-			f.flushBlock(blockNoI, imbI)
+	if f.writable() {
+		for _, blockNoI := range f.cache.Keys() {
+			imbI, ok := f.cache.Get(blockNoI)
+			if ok {
+				f.flushBlock(blockNoI, imbI)
+			}
 		}
+		if f.blockZeroModified() {
+			f.flushBlockZero()
+		}
+		f.updateParity()
+	}
+	if f.pendingErr != nil {
+		return *f.pendingErr
 	}
-	f.flushBlockZero()
 	return f.file.Sync()
 }
 
 func (f *File) Truncate(size int64) error {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
+	defer f.resealCache()
 	if f.pendingErr != nil {
 		return *f.pendingErr
 	}
+	if err := f.checkWritable("truncate"); err != nil {
+		return err
+	}
 	if size < 0 || uint64(size) > f.blockZero.BEncFileSize {
 		return os.ErrInvalid
 	}
@@ -559,8 +1022,11 @@ func (f *File) Truncate(size int64) error {
 		if err != nil {
 			return err
 		}
-		imb.plainText = imb.plainText[0 : size%int64(f.blockZero.BEncBlockSize)]
+		keep := size % int64(f.blockZero.BEncBlockSize)
+		clear(imb.plainText[keep:]) // beyond its length, the encrypted cache would leave it in plaintext
+		imb.plainText = imb.plainText[0:keep]
 		imb.modified = true
+		f.markDirty(blockNo)
 	}
 
 	if partial {
@@ -574,7 +1040,16 @@ func (f *File) Truncate(size int64) error {
 	}
 
 	f.blockZero.BEncFileSize = uint64(size)
-	return f.file.Truncate(int64(HeaderLength) + int64(f.blockZero.DiskBlockSize)*blockNo)
+	f.wrote()
+	if f.rs != nil {
+		for group := range f.staleGroups {
+			if group*int64(f.ext.ParityData) >= blockNo {
+				delete(f.staleGroups, group)
+			}
+		}
+		f.markParityStale(blockNo - 1)
+	}
+	return f.file.Truncate(f.diskSizeForBlocks(blockNo))
 }
 
 func (f *File) Stat() (*FileInfo, error) {
@@ -584,6 +1059,10 @@ func (f *File) Stat() (*FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	kdf, err := f.kdfParameters(&f.header)
+	if err != nil {
+		return nil, err
+	}
 
 	return &FileInfo{
 		name:          f.Name(),
@@ -599,6 +1078,9 @@ func (f *File) Stat() (*FileInfo, error) {
 		scryptN:       f.header.ScriptN,
 		scryptR:       f.header.ScriptR,
 		scryptP:       f.header.ScriptP,
+		kdf:           kdf,
+		parity:        ParityParameters{Data: f.ext.ParityData, Parity: f.ext.ParityShards},
+		attrs:         f.copyAttrs(),
 	}, nil
 }
 
@@ -616,6 +1098,9 @@ type FileInfo struct {
 	scryptN       uint32
 	scryptR       uint32
 	scryptP       uint32
+	kdf           crypto.KDFParameters
+	parity        ParityParameters
+	attrs         map[string]string
 }
 
 func (s FileInfo) Name() string {
@@ -652,17 +1137,65 @@ func (s FileInfo) SCryptParameters() (salt []byte, N, R, P uint32) {
 	return s.scryptSalt, s.scryptN, s.scryptR, s.scryptP
 }
 
+// KDF returns the key derivation parameters, crypto.SCryptParameters or crypto.Argon2idParameters
+func (s FileInfo) KDF() crypto.KDFParameters {
+	return s.kdf
+}
+
+func (s FileInfo) Parity() ParityParameters {
+	return s.parity
+}
+
+// Attrs returns a copy of the file attributes, see File.SetAttr
+func (s FileInfo) Attrs() map[string]string {
+	return s.attrs
+}
+
 func (f *File) Close() error {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
+	f.stopFlusher()
 	if f.pendingErr != nil && f.pendingErr != &os.ErrClosed {
 		return *f.pendingErr
 	}
 	f.cache.Purge()
-	f.flushBlockZero()
+	var err error
+	if f.writable() {
+		if f.blockZeroModified() { // unmodified files are not written, i.e. only opened to repair blocks
+			f.flushBlockZero()
+		}
+		err = f.updateParity() // without parity, damaged blocks could not be repaired
+	}
+	if err == nil && f.durability >= DurabilityClose && f.writable() {
+		if f.pendingErr != nil {
+			err = *f.pendingErr
+		} else {
+			err = f.file.Sync()
+		}
+	}
 	closedErr := os.ErrClosed
 	f.pendingErr = &closedErr
-	return f.file.Close()
+	f.aead = [3]cipher.AEAD{} // key schedules are held by the Go runtime, references are dropped
+	if f.secure != nil {
+		_ = f.secure.destroy()
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writable tells if the file was opened for writing, read only files are never written (block zero and parity too)
+func (f *File) writable() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+// checkWritable fails modifying a read only file, as os.File does
+func (f *File) checkWritable(op string) error {
+	if !f.writable() {
+		return &os.PathError{Op: op, Path: f.file.Name(), Err: os.ErrPermission}
+	}
+	return nil
 }
 
 func (f *File) Name() string {
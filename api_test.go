@@ -12,15 +12,15 @@ const (
 	BEBlockSize = 1024
 )
 
-func TestNoUsableMethods(t *testing.T) {
+func TestNoCredentials(t *testing.T) {
 	if _, err := Create("any"); err == nil {
-		t.Fatal("Create should not work, use CreateExt")
+		t.Fatal("Create should require a password, key or key provider")
 	}
 	if _, err := Open("any"); err == nil {
-		t.Fatal("Open should not work, use OpenExt")
+		t.Fatal("Open should require a password, key or key provider")
 	}
 	if _, err := OpenFile("any", 0, os.ModeAppend); err == nil {
-		t.Fatal("OpenFile should not work, use OpenExt")
+		t.Fatal("OpenFile should require a password, key or key provider")
 	}
 }
 
@@ -35,9 +35,9 @@ func TestCreateExt_InvalidArguments(t *testing.T) {
 			t.Fatal("block size should be: 1kb<=block_size<128kb")
 		}
 	}
-	for _, memBuffers := range []int{-123, -1, 0, 129, 65535} {
+	for _, memBuffers := range []int{-123, -1, 0, 1025, 65535} {
 		if _, err := CreateExt("file", []byte(password), crypto.MinSCryptParameters, BEBlockSize, memBuffers); err == nil {
-			t.Fatal("memory buffers be: 1<=buffers<128")
+			t.Fatal("memory buffers be: 1<=buffers<=1024")
 		}
 	}
 	_, err := CreateExt("", []byte(password), crypto.MinSCryptParameters, BEBlockSize, 1)
@@ -46,6 +46,14 @@ func TestCreateExt_InvalidArguments(t *testing.T) {
 	}
 }
 
+func TestCreateExtParity_InvalidArguments(t *testing.T) {
+	for _, parity := range []ParityParameters{{0, 1}, {1, 0}, {65, 1}, {4, 17}} {
+		if _, err := CreateExtParity("file", []byte(password), crypto.MinSCryptParameters, parity, BEBlockSize, 1); err == nil {
+			t.Fatal("parity parameters should be checked for bounds")
+		}
+	}
+}
+
 func TestOpenExt_InvalidArguments(t *testing.T) {
 	for _, buffers := range []int{-123, -1, 0, 1025, 128 * 1024} {
 		_, err := OpenExt("file", []byte(password), buffers)
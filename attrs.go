@@ -0,0 +1,128 @@
+package seof
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"sort"
+)
+
+// Attributes are small key/value pairs (i.e. content type, original filename) stored in block zero after BlockZero, so
+// they are encrypted and authenticated as any other block. All of them have to fit in one block.
+
+const blockZeroTagAttr uint16 = 1
+
+// SetAttr sets an attribute, it is persisted on Sync or Close
+func (f *File) SetAttr(key, value string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.pendingErr != nil {
+		return *f.pendingErr
+	}
+	if err := f.checkWritable("write"); err != nil {
+		return err
+	}
+	if len(key) == 0 || len(key) > 255 {
+		return errors.New("attribute key length has to be between 1 and 255")
+	}
+	if 1+len(key)+len(value) > math.MaxUint16 {
+		return errors.New("attribute too big")
+	}
+	previous, existed := f.attrs[key]
+	if f.attrs == nil {
+		f.attrs = make(map[string]string)
+	}
+	f.attrs[key] = value
+	if len(f.blockZeroBytes()) > int(f.blockZero.BEncBlockSize) {
+		if existed {
+			f.attrs[key] = previous
+		} else {
+			delete(f.attrs, key)
+		}
+		return errors.New("attributes do not fit in block zero")
+	}
+	f.attrsModified = true
+	f.wrote()
+	return nil
+}
+
+func (f *File) GetAttr(key string) (string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	value, ok := f.attrs[key]
+	return value, ok
+}
+
+func (f *File) RemoveAttr(key string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.pendingErr != nil {
+		return *f.pendingErr
+	}
+	if err := f.checkWritable("write"); err != nil {
+		return err
+	}
+	if _, ok := f.attrs[key]; !ok {
+		return os.ErrNotExist
+	}
+	delete(f.attrs, key)
+	f.attrsModified = true
+	f.wrote()
+	return nil
+}
+
+// ListAttrs returns the attribute keys, sorted
+func (f *File) ListAttrs() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return sortedKeys(f.attrs)
+}
+
+func (f *File) copyAttrs() map[string]string {
+	attrs := make(map[string]string, len(f.attrs))
+	for k, v := range f.attrs {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// blockZeroBytes is block zero plainText: BlockZero followed by the attributes
+func (f *File) blockZeroBytes() []byte {
+	buf := bytes.NewBuffer(f.blockZero.Bytes())
+	for _, key := range sortedKeys(f.attrs) {
+		value := make([]byte, 0, 1+len(key)+len(f.attrs[key]))
+		value = append(value, uint8(len(key)))
+		value = append(value, key...)
+		value = append(value, f.attrs[key]...)
+		writeRecord(buf, blockZeroTagAttr, value)
+	}
+	return buf.Bytes()
+}
+
+func attrsFromBlockZeroBytes(b []byte) (map[string]string, error) {
+	if len(b) < blockZeroLength {
+		return nil, errors.New("block zero too short")
+	}
+	attrs := make(map[string]string)
+	err := forEachRecord(b[blockZeroLength:], func(tag uint16, value []byte) error {
+		if tag != blockZeroTagAttr {
+			return errors.New("block zero: unsupported record")
+		}
+		if len(value) < 1 || len(value) < 1+int(value[0]) {
+			return errors.New("block zero: invalid attribute")
+		}
+		attrs[string(value[1:1+value[0]])] = string(value[1+value[0]:])
+		return nil
+	})
+	return attrs, err
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,99 @@
+package seof
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestAttrs_Persisted(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 1)
+	assertNoErr(err, t)
+	assertNoErr(f.SetAttr("content-type", "text/plain"), t)
+	assertNoErr(f.SetAttr("owner", "someone"), t)
+	assertNoErr(f.SetAttr("empty", ""), t)
+	assertNoErr(f.SetAttr("owner", "someone else"), t)
+	_, err = f.WriteString("HELLO")
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	f, err = OpenFile(tempFile.Name(), os.O_RDWR, 0, WithPassword([]byte(password)), WithCacheSize(1))
+	assertNoErr(err, t)
+	if !reflect.DeepEqual(f.ListAttrs(), []string{"content-type", "empty", "owner"}) {
+		t.Fatal(f.ListAttrs())
+	}
+	if value, ok := f.GetAttr("owner"); !ok || value != "someone else" {
+		t.Fatal()
+	}
+	if _, ok := f.GetAttr("nope"); ok {
+		t.Fatal()
+	}
+	stats, err := f.Stat()
+	assertNoErr(err, t)
+	if stats.Attrs()["content-type"] != "text/plain" || len(stats.Attrs()) != 3 {
+		t.Fatal()
+	}
+	assertNoErr(f.RemoveAttr("owner"), t)
+	if f.RemoveAttr("owner") != os.ErrNotExist {
+		t.Fatal()
+	}
+	b := make([]byte, 10)
+	n, err := f.Read(b)
+	assertNoErr(err, t)
+	if string(b[:n]) != "HELLO" {
+		t.Fatal()
+	}
+	assertNoErr(f.Close(), t)
+
+	raw, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+	if strings.Contains(string(raw), "text/plain") {
+		t.Fatal("attributes should be encrypted")
+	}
+}
+
+func TestAttrs_InvalidArguments(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 1)
+	assertNoErr(err, t)
+	if f.SetAttr("", "value") == nil || f.SetAttr(strings.Repeat("k", 256), "value") == nil {
+		t.Fatal("key length should be checked")
+	}
+	if f.SetAttr("big", strings.Repeat("v", BEBlockSize)) == nil {
+		t.Fatal("attributes should fit in block zero")
+	}
+	assertNoErr(f.SetAttr("big", strings.Repeat("v", 900)), t)
+	if f.SetAttr("big", strings.Repeat("v", 1000)) == nil {
+		t.Fatal("attributes should fit in block zero")
+	}
+	if value, _ := f.GetAttr("big"); len(value) != 900 {
+		t.Fatal("a failed SetAttr should not change the previous value")
+	}
+	assertNoErr(f.Close(), t)
+	if f.SetAttr("key", "value") != os.ErrClosed {
+		t.Fatal()
+	}
+}
+
+func TestAttrs_FromBlockZeroBytes(t *testing.T) {
+	bz := BlockZero{BEncBlockSize: 1024}
+	attrs, err := attrsFromBlockZeroBytes(bz.Bytes())
+	assertNoErr(err, t)
+	if len(attrs) != 0 {
+		t.Fatal("files without attributes only hold BlockZero")
+	}
+	if _, err = attrsFromBlockZeroBytes(append(bz.Bytes(), 2, 0, 1, 0, 0)); err == nil {
+		t.Fatal("unknown records should fail")
+	}
+	if _, err = attrsFromBlockZeroBytes(append(bz.Bytes(), 1, 0, 1, 0, 5)); err == nil {
+		t.Fatal("invalid attributes should fail")
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -174,6 +174,34 @@ func Test_ClosingAnErroredSoefIsOK(t *testing.T) {
 	assertNoErr(err, t)
 }
 
+func TestFile_ReadOnlyIsNotWritten(t *testing.T) {
+	tempFile, _ := ioutil.TempFile(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	f, err := Create(tempFile.Name(), WithPassword([]byte(password)), WithKDF(crypto.MinSCryptParameters),
+		WithBlockSize(BEBlockSize), WithParity(ParityParameters{Data: 4, Parity: 2}))
+	assertNoErr(err, t)
+	_, err = f.Write(crypto.RandBytes(BEBlockSize * 5))
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	disk, _ := os.ReadFile(tempFile.Name())
+
+	f, err = Open(tempFile.Name(), WithPassword([]byte(password)))
+	assertNoErr(err, t)
+	_, err = f.Read(make([]byte, 100))
+	assertNoErr(err, t)
+	if _, err = f.WriteAt([]byte{1}, 0); !errors.Is(err, os.ErrPermission) {
+		t.Fatal(err)
+	}
+	assertNoErr(f.Sync(), t)
+	if f.Stats().PendingErrors != 0 {
+		t.Fatal("a read only file should not be written")
+	}
+	assertNoErr(f.Close(), t)
+	if after, _ := os.ReadFile(tempFile.Name()); !bytes.Equal(disk, after) {
+		t.Fatal("modified")
+	}
+}
+
 func TestFile_Name(t *testing.T) {
 	tempFile, _ := ioutil.TempFile(os.TempDir(), "lala")
 	defer deferredCleanup(tempFile)
@@ -269,7 +297,7 @@ func TestFile_Truncate(t *testing.T) {
 	if stats == nil {
 		t.Fatal()
 	}
-	exp := int64(HeaderLength) + int64(5*f.blockZero.DiskBlockSize) // 4+1=5 because block-zero
+	exp := f.dataOffset + int64(5*f.blockZero.DiskBlockSize) // 4+1=5 because block-zero
 	if stats.Size() != exp {
 		t.Fatal("seems it did not truncate at the right place", stats.Size(), "!=", exp)
 	}
@@ -300,7 +328,7 @@ func TestFile_Truncate(t *testing.T) {
 	if stats == nil {
 		t.Fatal()
 	}
-	if stats.Size() != int64(HeaderLength)+int64(3*f.blockZero.DiskBlockSize) { // +1 for blockzero
+	if stats.Size() != f.dataOffset+int64(3*f.blockZero.DiskBlockSize) { // +1 for blockzero
 		t.Fatal("seems it did not truncate at the right place")
 	}
 }
@@ -347,8 +375,9 @@ func TestFile_SparseFile(t *testing.T) {
 	if n != 0 || err == nil {
 		t.Fatal()
 	}
-	if err.Error() != "cipher: message authentication failed" {
-		t.Fatal()
+	var corrupt *ErrCorruptBlock
+	if !errors.As(err, &corrupt) || corrupt.BlockNo != 500_000_005/BEBlockSize+1 || corrupt.Err != errNotAuthentic {
+		t.Fatal(err)
 	}
 }
 
@@ -404,8 +433,8 @@ func TestOpenExt_EmptyFile(t *testing.T) {
 	assertNoErr(f.Close(), t)
 
 	_, err = OpenExt(tempFile.Name(), []byte(password), 1)
-	if err != io.EOF {
-		t.Fatal()
+	if err != ErrTruncated {
+		t.Fatal(err)
 	}
 }
 
@@ -428,8 +457,9 @@ func TestOpenExt_InvalidHeader(t *testing.T) {
 	assertNoErr(f.Close(), t)
 
 	_, err = OpenExt(tempFile.Name(), []byte(password), 1)
-	if err != nil && err.Error() != "header: invalid disk_block_size" {
-		t.Fatal()
+	var invalid *ErrInvalidHeader
+	if !errors.As(err, &invalid) || invalid.Field != "disk_block_size" || err.Error() != "header: invalid disk_block_size" {
+		t.Fatal(err)
 	}
 }
 
@@ -453,8 +483,34 @@ func TestOpenExt_ValidHeader_TruncatedFile(t *testing.T) {
 	assertNoErr(f.Close(), t)
 
 	_, err = OpenExt(tempFile.Name(), []byte(password), 1)
-	if err != io.EOF {
-		t.Fatal()
+	if err != ErrTruncated {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenExt_CorruptedCipherTextLength(t *testing.T) {
+	tempFile, _ := ioutil.TempFile(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 1)
+	assertNoErr(err, t)
+	_, err = f.Write(crypto.RandBytes(BEBlockSize * 2))
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	disk, err := os.OpenFile(tempFile.Name(), os.O_RDWR, 0)
+	assertNoErr(err, t)
+	_, err = disk.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, f.dataOffset+int64(f.blockZero.DiskBlockSize)+int64(nonceSize))
+	assertNoErr(err, t)
+	assertNoErr(disk.Close(), t)
+
+	f, err = OpenExt(tempFile.Name(), []byte(password), 1)
+	assertNoErr(err, t)
+	_, err = f.Read(make([]byte, 10))
+	var corrupt *ErrCorruptBlock
+	if !errors.As(err, &corrupt) || corrupt.BlockNo != 1 || corrupt.Err.Error() != "invalid cipherText length" ||
+		corrupt.Offset != f.dataOffset+int64(f.blockZero.DiskBlockSize) {
+		t.Fatal(err)
 	}
 }
 
@@ -469,3 +525,22 @@ func deferredCleanup(file *os.File) {
 		_ = os.Remove(file.Name())
 	}
 }
+
+// openWithPassword opens files with the test password and the minimum scrypt parameters, for givenFile
+func openWithPassword(name string, flag int, perm os.FileMode, opts ...Option) (*File, error) {
+	opts = append([]Option{WithPassword([]byte(password)), WithKDF(crypto.MinSCryptParameters)}, opts...)
+	return OpenFile(name, flag, perm, opts...)
+}
+
+// givenFile creates a file with open (openWithPassword, or a Keyring's OpenFile), BEBlockSize blocks, one buffer and
+// the options, and writes data. It is closed when the test ends, tests reading it from disk close it first.
+func givenFile(t *testing.T, open func(string, int, os.FileMode, ...Option) (*File, error), name string, data []byte,
+	opts ...Option) *File {
+	opts = append([]Option{WithBlockSize(BEBlockSize), WithCacheSize(1)}, opts...)
+	f, err := open(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600, opts...)
+	assertNoErr(err, t)
+	t.Cleanup(func() { _ = f.Close() })
+	_, err = f.Write(data)
+	assertNoErr(err, t)
+	return f
+}
@@ -0,0 +1,85 @@
+package seof
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kuking/seof/crypto"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Encrypted cache: cached blocks are kept encrypted with ChaCha20 under an ephemeral per-process key. Blocks are
+// encrypted in place (the keystream does not change their length) with a new nonce every time, and decrypted only while
+// an operation is using them: one block at a time, re-encrypted before the next one is used or the operation returns.
+
+var (
+	cacheKeyOnce sync.Once
+	cacheKey     []byte
+	cacheNonce   atomic.Uint64 // nonces are never reused in the process
+)
+
+// UseEncryptedCache keeps the cached blocks encrypted, bounding the plaintext exposure to the block being read or
+// written. It costs CPU (a ChaCha20 pass per block access), intended for long living processes keeping files open.
+func (f *File) UseEncryptedCache() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.pendingErr != nil {
+		return *f.pendingErr
+	}
+	if f.encryptedCache {
+		return nil
+	}
+	cacheKeyOnce.Do(func() {
+		cacheKey = crypto.RandBytes(chacha20.KeySize)
+	})
+	f.encryptedCache = true
+	for _, blockNo := range f.cache.Keys() {
+		if value, ok := f.cache.Peek(blockNo); ok {
+			sealCachedBlock(value.(*inMemoryBlock))
+		}
+	}
+	return nil
+}
+
+// openBlock decrypts a cached block to be used, re-encrypting the previously used one
+func (f *File) openBlock(imb *inMemoryBlock) {
+	if !f.encryptedCache {
+		return
+	}
+	if f.openedBlock != imb {
+		f.resealCache()
+	}
+	if imb.sealed {
+		xorCachedBlock(imb)
+		imb.sealed = false
+	}
+	f.openedBlock = imb
+}
+
+// resealCache re-encrypts the block in use, if any
+func (f *File) resealCache() {
+	if f.openedBlock != nil {
+		sealCachedBlock(f.openedBlock)
+		f.openedBlock = nil
+	}
+}
+
+func sealCachedBlock(imb *inMemoryBlock) {
+	if imb.sealed || imb.plainText == nil {
+		return // already sealed, or evicted
+	}
+	imb.nonce = cacheNonce.Add(1)
+	xorCachedBlock(imb)
+	imb.sealed = true
+}
+
+func xorCachedBlock(imb *inMemoryBlock) {
+	nonce := make([]byte, chacha20.NonceSize)
+	binary.LittleEndian.PutUint64(nonce, imb.nonce)
+	stream, err := chacha20.NewUnauthenticatedCipher(cacheKey, nonce)
+	if err != nil {
+		panic(err) // key and nonce sizes are fixed
+	}
+	stream.XORKeyStream(imb.plainText, imb.plainText)
+}
@@ -0,0 +1,97 @@
+package seof
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestFile_UseEncryptedCache(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	reference := crypto.RandBytes(BEBlockSize*12 + 77)
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 4)
+	assertNoErr(err, t)
+	_, err = f.Write(reference[:BEBlockSize*2])
+	assertNoErr(err, t)
+	assertNoErr(f.UseEncryptedCache(), t)
+	_, err = f.Write(reference[BEBlockSize*2:])
+	assertNoErr(err, t)
+	assertCacheSealed(t, f, reference)
+
+	// random overwrites and reads, with evictions and syncs in between
+	for i := 0; i < 200; i++ {
+		ofs := rand.Intn(len(reference) - 100)
+		chunk := crypto.RandBytes(1 + rand.Intn(100))
+		copy(reference[ofs:], chunk)
+		_, err = f.WriteAt(chunk, int64(ofs))
+		assertNoErr(err, t)
+		read := make([]byte, 1+rand.Intn(3*BEBlockSize))
+		ofs = rand.Intn(len(reference) - len(read))
+		_, err = f.ReadAt(read, int64(ofs))
+		assertNoErr(err, t)
+		if !bytes.Equal(reference[ofs:ofs+len(read)], read) {
+			t.Fatal("read differs at", ofs)
+		}
+		if i%50 == 0 {
+			assertNoErr(f.Sync(), t)
+		}
+	}
+	assertCacheSealed(t, f, reference)
+	assertNoErr(f.Close(), t)
+
+	f, err = OpenExt(tempFile.Name(), []byte(password), 4)
+	assertNoErr(err, t)
+	assertNoErr(f.UseSecureMemory(), t)
+	assertNoErr(f.UseEncryptedCache(), t)
+	assertNoErr(f.Verify(), t)
+	read, err := io.ReadAll(f)
+	assertNoErr(err, t)
+	if !bytes.Equal(reference, read) {
+		t.Fatal()
+	}
+	assertCacheSealed(t, f, reference)
+	assertNoErr(f.Close(), t)
+}
+
+func assertCacheSealed(t *testing.T, f *File, reference []byte) {
+	for _, blockNo := range f.cache.Keys() {
+		value, _ := f.cache.Peek(blockNo)
+		imb := value.(*inMemoryBlock)
+		if !imb.sealed {
+			t.Fatal("block", blockNo, "should be encrypted in the cache")
+		}
+		if blockNo.(int64) > 0 && len(imb.plainText) > 16 && bytes.Contains(reference, imb.plainText[:16]) {
+			t.Fatal("block", blockNo, "plainText in the cache")
+		}
+	}
+}
+
+func TestFile_UseEncryptedCache_Truncate(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 4)
+	assertNoErr(err, t)
+	assertNoErr(f.UseEncryptedCache(), t)
+	reference := crypto.RandBytes(BEBlockSize * 2)
+	_, err = f.Write(reference)
+	assertNoErr(err, t)
+	assertNoErr(f.Truncate(BEBlockSize+10), t)
+
+	value, ok := f.cache.Peek(int64(2))
+	if !ok {
+		t.Fatal("the truncated block should be cached")
+	}
+	imb := value.(*inMemoryBlock)
+	if len(imb.plainText) != 10 || !isZeroed(imb.plainText[len(imb.plainText):cap(imb.plainText)]) {
+		t.Fatal("the truncated plaintext should be wiped")
+	}
+	assertCacheSealed(t, f, reference)
+	assertNoErr(f.Close(), t)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/kuking/seof"
+	"github.com/kuking/seof/agent"
+	"github.com/kuking/seof/crypto"
+)
+
+func cmdAgent(args []string) int {
+	fs := newFlagSet("agent", "")
+	defaultSocket := os.Getenv(agent.SocketEnv)
+	if defaultSocket == "" {
+		defaultSocket = agent.DefaultSocket()
+	}
+	socket := fs.String("socket", defaultSocket, "Unix socket path")
+	ttl := fs.Duration("ttl", 15*time.Minute, "time derived keys and password salts are kept")
+	purge := fs.Bool("purge", false, "wipes the keys held by the running agent and exits")
+	if err := fs.Parse(args); err != nil {
+		return -1
+	}
+	if *purge {
+		if err := (&agent.Client{Socket: *socket}).Purge(context.Background()); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	l, err := agent.Listen(*socket)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cache := seof.NewKeyCache(*ttl)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		_ = l.Close()
+	}()
+	fmt.Printf("%v=%v; export %v;\n", agent.SocketEnv, *socket, agent.SocketEnv)
+	err = agent.Serve(l, cache)
+	cache.Purge()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// useKeyCache derives keys with the agent in $SEOF_AGENT_SOCK when set, and with a cache for this invocation otherwise
+// (or when the agent fails): files sharing a password salt derive their password key once. Returns the local cache,
+// to be purged on exit.
+func useKeyCache() *seof.KeyCache {
+	local := seof.NewKeyCache(localKeyTTL)
+	if socket := os.Getenv(agent.SocketEnv); socket != "" {
+		seof.SetKeyCache(&agentOrLocal{client: &agent.Client{Socket: socket}, cache: local})
+	} else {
+		seof.SetKeyCache(local)
+	}
+	return local
+}
+
+const localKeyTTL = 24 * time.Hour // for the invocation
+
+// agentOrLocal derives keys with the agent, locally after warning when the agent fails
+type agentOrLocal struct {
+	client *agent.Client
+	cache  *seof.KeyCache
+	local  atomic.Bool
+}
+
+func (a *agentOrLocal) DeriveKey(ctx context.Context, kdf crypto.KDFParameters, password []byte, salt []byte, keyLen int) ([]byte, error) {
+	if !a.local.Load() {
+		key, err := a.client.DeriveKey(ctx, kdf, password, salt, keyLen)
+		if err == nil || ctx.Err() != nil {
+			return key, err
+		}
+		a.warn(err)
+	}
+	return a.cache.DeriveKey(ctx, kdf, password, salt, keyLen)
+}
+
+func (a *agentOrLocal) PasswordSalt(ctx context.Context, kdf crypto.KDFParameters, password []byte) ([]byte, error) {
+	if !a.local.Load() {
+		salt, err := a.client.PasswordSalt(ctx, kdf, password)
+		if err == nil || ctx.Err() != nil {
+			return salt, err
+		}
+		a.warn(err)
+	}
+	return a.cache.PasswordSalt(ctx, kdf, password)
+}
+
+func (a *agentOrLocal) warn(err error) {
+	if a.local.CompareAndSwap(false, true) {
+		_, _ = fmt.Fprintf(os.Stderr, "WARNING: %v, deriving keys without the agent\n", err)
+	}
+}
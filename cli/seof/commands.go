@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kuking/seof"
+	"github.com/kuking/seof/crypto"
+	"golang.org/x/term"
+)
+
+const memoryBuffers = 10
+const seofSuffix = ".seof"
+
+func newFlagSet(name string, files string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage of seof %v:\n\n  seof %v [options] %v\n\nOptions:\n", name, name, files)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses the command line returning the files, or nil after printing the problem
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	if err := fs.Parse(args); err != nil {
+		return nil
+	}
+	if fs.NArg() == 0 {
+		_, _ = fmt.Fprintln(fs.Output(), "no files provided.")
+		fs.Usage()
+		return nil
+	}
+	return fs.Args()
+}
+
+// kdfFlags registers the key derivation options, returning their parser
+func kdfFlags(fs *flag.FlagSet, label string) func() (crypto.KDFParameters, error) {
+	kdf := fs.String("kdf", "scrypt", label+"key derivation function: scrypt, argon2id")
+	scryptCli := fs.String("scrypt", "default", label+"Scrypt parameters: min, default, better, max, auto:time[:max memory]")
+	argon2idCli := fs.String("argon2id", "default", label+"Argon2id parameters: min, default, better, max, time:memory:threads")
+	return func() (crypto.KDFParameters, error) {
+		return parseKDF(*kdf, *scryptCli, *argon2idCli)
+	}
+}
+
+func recursiveFlags(fs *flag.FlagSet) (recursive *bool, workers *int, follow *bool) {
+	recursive = fs.Bool("r", false, "recursive, mirrors a source directory into a destination directory, "+
+		"skipping files with the same modification time")
+	workers = fs.Int("j", defaultWorkers(), "recursive: files processed concurrently")
+	follow = fs.Bool("L", false, "recursive: follow symbolic links to files (default: skip them)")
+	return
+}
+
+// flagSet tells if the flag was given in the command line
+func flagSet(fs *flag.FlagSet, name string) (set bool) {
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return
+}
+
+func validOutputFlags(output string, recursive bool, files []string) bool {
+	if recursive && (len(files) != 2 || output != "") {
+		_, _ = fmt.Fprintln(os.Stderr, "-r requires a source and a destination directory, and no -o")
+		return false
+	}
+	if output != "" && len(files) != 1 {
+		_, _ = fmt.Fprintln(os.Stderr, "-o can only be used with one input file")
+		return false
+	}
+	return true
+}
+
+// forEachFile runs op for every file reporting failures, it does not stop on failures. Returns the exit code.
+func forEachFile(files []string, op func(file string) error) int {
+	failed := 0
+	for _, file := range files {
+		if err := op(file); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%v: %v\n", file, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "%v of %v files failed\n", failed, len(files))
+		return 1
+	}
+	return 0
+}
+
+func cmdEncrypt(args []string) int {
+	fs := newFlagSet("encrypt", "<files...> (- for stdin, requires -o) | -r <source dir> <destination dir>")
+	passwordSrc := passwordFlags(fs, "", "password")
+	kdfParams := kdfFlags(fs, "")
+	parityCli := fs.String("parity", "", "Reed-Solomon parity as data:parity blocks, i.e. 16:2 (default: none)")
+	blockSize := fs.Uint("s", 1024, "block size")
+	output := fs.String("o", "", "output file, only for one input file, - streams to stdout "+
+		"(default: input file + .seof)")
+	force := fs.Bool("force", false, "overwrite existing output files")
+	keyCheck := fs.Bool("key-check", false, "store a key check value, telling a wrong password from a damaged file "+
+		"(older versions can not open it)")
+	sharedSalt := fs.Bool("shared-salt", false, "share a password salt with the files encrypted meanwhile with the "+
+		"same password, deriving the key once for all of them (older versions can not open them; default with -r)")
+	recursive, workers, follow := recursiveFlags(fs)
+	files := parseFlags(fs, args)
+	if files == nil || !validOutputFlags(*output, *recursive, files) {
+		return -1
+	}
+	if *recursive && !flagSet(fs, "shared-salt") {
+		*sharedSalt = true
+	}
+	if err := passwordSrc.checkStdin(files); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return -1
+	}
+	password, err := passwordSrc.read(true)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not read password: %v\n", err)
+		return -1
+	}
+	if !enoughEntropy(password) {
+		return -1
+	}
+	kdf, err := kdfParams()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return -1
+	}
+	parity, err := parseParity(*parityCli)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Parity parameter not recognised: %v\n", err)
+		return -1
+	}
+	if *output == "-" {
+		if parity.Enabled() {
+			_, _ = fmt.Fprintln(os.Stderr, "streams to stdout do not support parity")
+			return -1
+		}
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			_, _ = fmt.Fprintln(os.Stderr, "not writing encrypted data to a terminal, redirect stdout")
+			return -1
+		}
+		return forEachFile(files, func(file string) error {
+			return streamFile(file, password, kdf, int(*blockSize))
+		})
+	}
+	opts := []seof.Option{seof.WithPassword(password), seof.WithKDF(kdf), seof.WithParity(parity),
+		seof.WithBlockSize(int(*blockSize)), seof.WithCacheSize(memoryBuffers),
+		seof.WithDurability(seof.DurabilityClose)} // synced before the output is committed
+	if *keyCheck {
+		opts = append(opts, seof.WithKeyCheck())
+	}
+	if *sharedSalt {
+		salt, err := seof.PasswordSaltFor(context.Background(), kdf, password)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "could not get the password salt: %v\n", err)
+			return -1
+		}
+		opts = append(opts, seof.WithPasswordSalt(salt))
+	}
+	create := func(name string) (*seof.File, error) {
+		return seof.Create(name, opts...)
+	}
+
+	if *recursive {
+		run := recursiveRun{
+			srcRoot: files[0], dstRoot: files[1], workers: *workers, follow: *follow, force: *force,
+			target: func(rel string) (string, bool) {
+				return rel + seofSuffix, true
+			},
+			process: func(src string, _ io.Closer, dst string, info os.FileInfo) error {
+				if err := encryptFile(src, dst, true, create); err != nil {
+					return err
+				}
+				return os.Chtimes(dst, info.ModTime(), info.ModTime())
+			},
+			modTime: func(_ string, _ io.Closer, info os.FileInfo) (time.Time, error) {
+				return info.ModTime(), nil
+			},
+			dstModTime: func(dst string, dstInfo os.FileInfo) (time.Time, error) {
+				return recordedModTime(dst, password, dstInfo)
+			},
+		}
+		return run.run()
+	}
+
+	return forEachFile(files, func(file string) error {
+		out := *output
+		if out == "" {
+			if file == "-" {
+				return errors.New("encrypting stdin requires -o")
+			}
+			out = file + seofSuffix
+		}
+		return encryptFile(file, out, *force, create)
+	})
+}
+
+// recordedModTime returns the source modification time recorded in an encrypted file, its own one when not recorded
+// (older versions): encrypted files copied without preserving times are still unchanged
+func recordedModTime(name string, password []byte, info os.FileInfo) (time.Time, error) {
+	ef, err := seof.OpenExt(name, password, 1)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = ef.Close() }()
+	meta, err := readMeta(ef)
+	if err != nil || !meta.hasTime {
+		return info.ModTime(), err
+	}
+	return meta.modTime, nil
+}
+
+// encryptFile encrypts a file (or stdin) into a seof file created by create, recording the original file metadata
+func encryptFile(file string, out string, force bool, create func(name string) (*seof.File, error)) error {
+	input, info, err := openInput(file)
+	if err != nil {
+		return err
+	}
+	defer closeInput(input)
+
+	ao, err := newAtomicOutput(out, force)
+	if err != nil {
+		return err
+	}
+	ef, err := create(ao.tmp)
+	if err != nil {
+		ao.abort()
+		return err
+	}
+	if info.Mode().IsRegular() {
+		err = recordMeta(ef, info, file != "-")
+	}
+	if err == nil {
+		_, err = io.Copy(ef, input)
+	}
+	if closeErr := ef.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		ao.abort()
+		return err
+	}
+	return ao.commit()
+}
+
+// streamFile encrypts a file (or stdin) into stdout, see seof.NewArchiveWriter
+func streamFile(file string, password []byte, kdf crypto.KDFParameters, blockSize int) error {
+	input, info, err := openInput(file)
+	if err != nil {
+		return err
+	}
+	defer closeInput(input)
+
+	out := bufio.NewWriterSize(os.Stdout, 64*1024)
+	sw, err := seof.NewArchiveWriter(out, password, kdf, blockSize)
+	if err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() {
+		err = recordMeta(sw, info, file != "-")
+	}
+	if err == nil {
+		_, err = io.Copy(sw, input)
+	}
+	if err == nil {
+		err = sw.Close()
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	return err
+}
+
+// openInput opens a file to be encrypted, stdin for -
+func openInput(file string) (*os.File, os.FileInfo, error) {
+	input := os.Stdin
+	if file != "-" {
+		var err error
+		input, err = os.Open(file)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	info, err := input.Stat()
+	if err == nil && info.IsDir() {
+		err = errors.New("is a directory")
+	}
+	if err != nil {
+		closeInput(input)
+		return nil, nil, err
+	}
+	return input, info, nil
+}
+
+func closeInput(input *os.File) {
+	if input != os.Stdin {
+		_ = input.Close()
+	}
+}
+
+func cmdDecrypt(args []string) int {
+	fs := newFlagSet("decrypt", "<files.seof...> (- for stdin, requires -o) | -r <source dir> <destination dir>")
+	passwordSrc := passwordFlags(fs, "", "password")
+	output := fs.String("o", "", "output file, only for one input file, - for stdout (default: input file without .seof)")
+	force := fs.Bool("force", false, "overwrite existing output files")
+	restoreMeta := fs.Bool("restore-meta", false, "restore the original file mode and modification time; without -o, "+
+		"the original file name is used too (in the input file directory). Always on with -r")
+	recursive, workers, follow := recursiveFlags(fs)
+	files := parseFlags(fs, args)
+	if files == nil || !validOutputFlags(*output, *recursive, files) {
+		return -1
+	}
+	if err := passwordSrc.checkStdin(files); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return -1
+	}
+	password, err := passwordSrc.read(false)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not read password: %v\n", err)
+		return -1
+	}
+
+	if *recursive {
+		run := recursiveRun{
+			srcRoot: files[0], dstRoot: files[1], workers: *workers, follow: *follow, force: *force,
+			target: func(rel string) (string, bool) {
+				if !strings.HasSuffix(rel, seofSuffix) || filepath.Base(rel) == seofSuffix {
+					return "", false
+				}
+				return strings.TrimSuffix(rel, seofSuffix), true
+			},
+			open: func(src string) (io.Closer, error) {
+				return passwordSrc.openFile(src, os.O_RDONLY)
+			},
+			process: func(src string, opened io.Closer, dst string, info os.FileInfo) error {
+				ef := opened.(*seof.File)
+				if err := decryptFile(ef, src, dst, true, true); err != nil {
+					return err
+				}
+				if meta, _ := readMeta(ef); !meta.hasTime { // not recorded, the seof file one
+					return os.Chtimes(dst, info.ModTime(), info.ModTime())
+				}
+				return nil
+			},
+			modTime: func(_ string, opened io.Closer, info os.FileInfo) (time.Time, error) {
+				meta, err := readMeta(opened.(*seof.File))
+				if err != nil || !meta.hasTime {
+					return info.ModTime(), err
+				}
+				return meta.modTime, nil
+			},
+		}
+		return run.run()
+	}
+
+	return forEachFile(files, func(file string) error {
+		var src decryptSource
+		if file == "-" {
+			if *output == "" {
+				return errors.New("decrypting stdin requires -o")
+			}
+			sr, err := seof.NewStreamReader(bufio.NewReaderSize(os.Stdin, 64*1024), password)
+			if err != nil {
+				return passwordSrc.hintRaw(err)
+			}
+			defer func() { _ = sr.Close() }()
+			src = sr
+		} else {
+			ef, err := passwordSrc.openFile(file, os.O_RDONLY)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = ef.Close() }()
+			src = ef
+		}
+		if *output == "-" {
+			_, err := io.Copy(os.Stdout, src)
+			return err
+		}
+		return decryptFile(src, file, *output, *force, *restoreMeta)
+	})
+}
+
+// decryptSource is a seof.File or a seof.StreamReader, the latter has its attributes only once read
+type decryptSource interface {
+	io.Reader
+	attrGetter
+}
+
+func decryptFile(src decryptSource, file string, out string, force bool, restoreMeta bool) error {
+	meta, err := readMeta(src)
+	if err != nil && restoreMeta {
+		return err
+	}
+	if out == "" && restoreMeta && meta.name != "" {
+		name := filepath.Base(meta.name)
+		if name == "." || name == ".." || name == string(filepath.Separator) {
+			return fmt.Errorf("invalid original file name recorded: %v", meta.name)
+		}
+		out = filepath.Join(filepath.Dir(file), name)
+	}
+	if out == "" {
+		if !strings.HasSuffix(file, seofSuffix) || len(file) == len(seofSuffix) {
+			return errors.New("can not tell the output file name, use -o")
+		}
+		out = strings.TrimSuffix(file, seofSuffix)
+	}
+
+	ao, err := newAtomicOutput(out, force)
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(ao.tmp, os.O_WRONLY|os.O_TRUNC, 0)
+	if err == nil {
+		_, err = io.Copy(dst, src)
+		if err == nil {
+			err = dst.Sync()
+		}
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil && restoreMeta {
+		if meta, err = readMeta(src); err == nil { // streams have their attributes at the end
+			err = applyMeta(ao.tmp, meta)
+		}
+	}
+	if err != nil {
+		ao.abort()
+		return err
+	}
+	return ao.commit()
+}
+
+func cmdCat(args []string) int {
+	fs := newFlagSet("cat", "<files.seof...>")
+	passwordSrc := passwordFlags(fs, "", "password")
+	offset := fs.String("offset", "", "start at this content offset, negative counts from the end (i.e. -10M), "+
+		"K, M, G and T suffixes accepted")
+	length := fs.String("length", "", "bytes to output from -offset (default: until the end)")
+	var ranges rangesFlag
+	fs.Var(&ranges, "range", "offset[:length] to output, can be repeated or comma separated, "+
+		"output in the given order (i.e. 0:1K,-1K)")
+	files := parseFlags(fs, args)
+	if files == nil {
+		return -1
+	}
+	if *offset != "" || *length != "" {
+		if *offset == "" {
+			*offset = "0"
+		}
+		if err := ranges.Set(*offset + ":" + *length); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "invalid -offset/-length: %v\n", err)
+			return -1
+		}
+		ranges = append(ranges[len(ranges)-1:], ranges[:len(ranges)-1]...)
+	}
+	_, err := passwordSrc.read(false)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not read password: %v\n", err)
+		return -1
+	}
+	return forEachFile(files, func(file string) error {
+		ef, err := passwordSrc.openFile(file, os.O_RDONLY)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = ef.Close() }()
+		if len(ranges) > 0 {
+			stats, err := ef.Stat()
+			if err != nil {
+				return err
+			}
+			return copyRanges(os.Stdout, ef, stats.Size(), ranges)
+		}
+		_, err = io.Copy(os.Stdout, ef)
+		return err
+	})
+}
+
+func cmdInfo(args []string) int {
+	fs := newFlagSet("info", "<files.seof...>")
+	passwordSrc := passwordFlags(fs, "", "password")
+	files := parseFlags(fs, args)
+	if files == nil {
+		return -1
+	}
+	_, err := passwordSrc.read(false)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not read password: %v\n", err)
+		return -1
+	}
+	return forEachFile(files, func(file string) error {
+		ef, err := passwordSrc.openFile(file, os.O_RDONLY)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = ef.Close() }()
+		if len(files) > 1 {
+			fmt.Printf("==> %v <==\n", file)
+		}
+		return printInfo(ef)
+	})
+}
+
+func cmdVerify(args []string) int {
+	fs := newFlagSet("verify", "<files.seof...>")
+	passwordSrc := passwordFlags(fs, "", "password")
+	repair := fs.Bool("repair", false, "write back the damaged blocks repaired with parity (opens the files for writing)")
+	files := parseFlags(fs, args)
+	if files == nil {
+		return -1
+	}
+	_, err := passwordSrc.read(false)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not read password: %v\n", err)
+		return -1
+	}
+	flag := os.O_RDONLY
+	if *repair {
+		flag = os.O_RDWR
+	}
+	return forEachFile(files, func(file string) error {
+		ef, err := passwordSrc.openFile(file, flag)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = ef.Close() }()
+		if err = ef.Verify(); err != nil {
+			return err
+		}
+		switch repaired := ef.Stats().BlocksRepaired; {
+		case repaired == 0:
+			fmt.Printf("%v: OK\n", file)
+		case *repair:
+			fmt.Printf("%v: OK, %v damaged blocks repaired\n", file, repaired)
+		default:
+			fmt.Printf("%v: OK, %v damaged blocks repaired in memory (-repair writes them back)\n", file, repaired)
+		}
+		return nil
+	})
+}
+
+func cmdPasswd(args []string) int {
+	fs := newFlagSet("passwd", "<files.seof...>")
+	passwordSrc := passwordFlags(fs, "", "current password")
+	newPasswordSrc := passwordFlags(fs, "new-", "new password")
+	kdfParams := kdfFlags(fs, "new ")
+	files := parseFlags(fs, args)
+	if files == nil {
+		return -1
+	}
+	_, err := passwordSrc.read(false)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not read password: %v\n", err)
+		return -1
+	}
+	newPassword, err := newPasswordSrc.read(true)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not read new password: %v\n", err)
+		return -1
+	}
+	if !enoughEntropy(newPassword) {
+		return -1
+	}
+	kdf, err := kdfParams()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return -1
+	}
+	return forEachFile(files, func(file string) error {
+		return passwordSrc.retryRaw(func(password []byte) error {
+			return seof.Rekey(file, password, newPassword, kdf, memoryBuffers)
+		})
+	})
+}
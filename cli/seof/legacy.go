@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kuking/seof"
+	"github.com/kuking/seof/crypto"
+)
+
+// flag based interface of previous versions: encrypts from stdin, decrypts to stdout
+
+var doEncrypt bool
+var passwordFile string
+var doHelp bool
+var doInfo bool
+var blockSize uint
+var scryptParamsCli string
+var kdfCli string
+var argon2idParamsCli string
+var parityCli string
+var inputFile string
+var doRestoreMeta bool
+
+func doArgsParsing() bool {
+	flag.BoolVar(&doEncrypt, "e", false, "encrypt (default: to decrypt)")
+	flag.StringVar(&scryptParamsCli, "scrypt", "default", "Encrypting Scrypt parameters: min, default, better, max, auto:time[:max memory]")
+	flag.StringVar(&kdfCli, "kdf", "scrypt", "Encrypting key derivation function: scrypt, argon2id")
+	flag.StringVar(&argon2idParamsCli, "argon2id", "default", "Encrypting Argon2id parameters: min, default, better, max, time:memory:threads")
+	flag.StringVar(&parityCli, "parity", "", "Encrypting Reed-Solomon parity as data:parity blocks, i.e. 16:2 (default: none)")
+	flag.StringVar(&inputFile, "in", "", "Encrypting from this file instead of stdin, recording its name, mode and modification time")
+	flag.BoolVar(&doRestoreMeta, "restore-meta", false, "Decrypting into the original file name (in the current directory), restoring its mode and modification time")
+	flag.BoolVar(&doInfo, "i", false, "show seof encrypted file metadata")
+	flag.StringVar(&passwordFile, "p", "", "password file")
+	flag.UintVar(&blockSize, "s", 1024, "block size")
+	flag.BoolVar(&doHelp, "h", false, "Show usage")
+	flag.Parse()
+	if doHelp || flag.NArg() != 1 {
+		usage()
+		fmt.Print("\nOptions without a command:\n\n")
+		flag.PrintDefaults()
+		return false
+	}
+	return true
+}
+
+func legacyMain() {
+
+	if !doArgsParsing() {
+		os.Exit(-1)
+	}
+
+	password, err := readPassword(passwordFile)
+	assertNoError(err, "FATAL: could not read password: %v")
+	if !enoughEntropy(password) {
+		os.Exit(-1)
+	}
+
+	var kdf crypto.KDFParameters
+	if doEncrypt {
+		kdf, err = parseKDF(kdfCli, scryptParamsCli, argon2idParamsCli)
+		assertNoError(err, "%v")
+	}
+
+	parity, err := parseParity(parityCli)
+	assertNoError(err, "Parity parameter not recognised: %v")
+
+	filename := flag.Arg(0)
+	var ef *seof.File
+	if doInfo || !doEncrypt {
+		ef, err = seof.OpenExt(filename, password, 10)
+	} else {
+		ef, err = seof.CreateExtParity(filename, password, kdf, parity, int(blockSize), 10)
+	}
+	assertNoError(err, "Failed to open file: "+filename+" -- %v")
+
+	if doInfo {
+		err = printInfo(ef)
+		assertNoError(err, "FATAL: problems doing file stats %v")
+	} else if doEncrypt {
+		input := os.Stdin
+		if inputFile != "" {
+			input, err = os.Open(inputFile)
+			assertNoError(err, "FATAL: could not open input file: %v")
+		}
+		var info os.FileInfo
+		info, err = input.Stat()
+		assertNoError(err, "FATAL: could not stat input: %v")
+		if info.Mode().IsRegular() {
+			err = recordMeta(ef, info, inputFile != "")
+			assertNoError(err, "FATAL: could not record original file metadata: %v")
+		}
+		_, err = io.Copy(ef, input)
+	} else if doRestoreMeta {
+		var name string
+		name, err = restoreMeta(ef)
+		if err == nil {
+			_, _ = os.Stderr.WriteString("decrypted into: " + name + "\n")
+		}
+	} else {
+		_, err = io.Copy(os.Stdout, ef)
+	}
+	assertNoError(err, "FATAL: io error: %v")
+
+	err = ef.Close()
+	assertNoError(err, "FATAL: could not close the seof file: %v")
+}
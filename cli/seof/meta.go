@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kuking/seof"
+)
+
+// original file metadata, recorded as encrypted seof attributes
+const (
+	attrName    = "seof.name"
+	attrMode    = "seof.mode"
+	attrModTime = "seof.mtime"
+)
+
+// attrSetter is a seof.File or a seof.StreamWriter
+type attrSetter interface {
+	SetAttr(key, value string) error
+}
+
+func recordMeta(ef attrSetter, info os.FileInfo, withName bool) error {
+	if withName {
+		if err := ef.SetAttr(attrName, info.Name()); err != nil {
+			return err
+		}
+	}
+	if err := ef.SetAttr(attrMode, fmt.Sprintf("%04o", info.Mode().Perm())); err != nil {
+		return err
+	}
+	return ef.SetAttr(attrModTime, info.ModTime().UTC().Format(time.RFC3339Nano))
+}
+
+type originalMeta struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+	hasMode bool
+	hasTime bool
+}
+
+// attrGetter is a seof.File or a seof.StreamReader
+type attrGetter interface {
+	GetAttr(key string) (string, bool)
+}
+
+func readMeta(ef attrGetter) (meta originalMeta, err error) {
+	meta.name, _ = ef.GetAttr(attrName)
+	if value, ok := ef.GetAttr(attrMode); ok {
+		var mode uint64
+		mode, err = strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			return
+		}
+		meta.mode, meta.hasMode = os.FileMode(mode).Perm(), true
+	}
+	if value, ok := ef.GetAttr(attrModTime); ok {
+		meta.modTime, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return
+		}
+		meta.hasTime = true
+	}
+	return
+}
+
+// restoreMeta decrypts into a new file in the current directory, named, permissioned and timestamped as the original
+func restoreMeta(ef *seof.File) (string, error) {
+	meta, err := readMeta(ef)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(meta.name)
+	if meta.name == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", errors.New("no valid original file name recorded")
+	}
+	out, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return name, err
+	}
+	_, err = io.Copy(out, ef)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return name, err
+	}
+	return name, applyMeta(name, meta)
+}
+
+func applyMeta(name string, meta originalMeta) error {
+	if meta.hasMode {
+		if err := os.Chmod(name, meta.mode); err != nil {
+			return err
+		}
+	}
+	if meta.hasTime {
+		return os.Chtimes(name, meta.modTime, meta.modTime)
+	}
+	return nil
+}
+
+func printMeta(ef *seof.File) {
+	meta, err := readMeta(ef)
+	if err != nil {
+		fmt.Printf("   Original Metadata: invalid (%v)\n", err)
+		return
+	}
+	if meta.name != "" {
+		fmt.Printf("  Original File Name: %v\n", meta.name)
+	}
+	if meta.hasMode {
+		fmt.Printf("  Original File Mode: %v\n", meta.mode)
+	}
+	if meta.hasTime {
+		fmt.Printf("   Original Mod Time: %v\n", meta.modTime.Local())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// atomicOutput is a temporary file next to its destination, renamed over it once it has been completely written. So a
+// failure half-way never leaves a truncated destination behind.
+type atomicOutput struct {
+	name  string
+	tmp   string
+	force bool
+}
+
+func newAtomicOutput(name string, force bool) (*atomicOutput, error) {
+	if !force {
+		if _, err := os.Lstat(name); err == nil {
+			return nil, existsError(name)
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	_ = tmp.Close()
+	return &atomicOutput{name: name, tmp: tmp.Name(), force: force}, nil
+}
+
+func existsError(name string) error {
+	return fmt.Errorf("%v already exists (use -force to overwrite)", name)
+}
+
+// commit renames the temporary file to the destination and syncs its directory. Writers sync the temporary file before
+// closing it, so a committed output survives a crash.
+func (o *atomicOutput) commit() error {
+	var err error
+	if o.force {
+		err = os.Rename(o.tmp, o.name)
+	} else {
+		err = renameNoReplace(o.tmp, o.name)
+	}
+	if err != nil {
+		o.abort()
+		return err
+	}
+	return syncDir(filepath.Dir(o.name))
+}
+
+// linkNoReplace renames without overwriting, also a destination created after newAtomicOutput checked it: linking
+// fails when the destination exists, the temporary name is removed once linked. In filesystems without hard links
+// (vfat, exFAT, many FUSE and SMB mounts) the destination is reserved with an exclusive create, then renamed over.
+func linkNoReplace(from, to string) error {
+	err := os.Link(from, to)
+	if err == nil {
+		return os.Remove(from)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return existsError(to)
+	}
+	if errors.Is(err, errors.ErrUnsupported) || errors.Is(err, fs.ErrPermission) {
+		return createNoReplace(from, to)
+	}
+	return err
+}
+
+func createNoReplace(from, to string) error {
+	reserved, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return existsError(to)
+	}
+	if err != nil {
+		return err
+	}
+	_ = reserved.Close()
+	if err = os.Rename(from, to); err != nil {
+		_ = os.Remove(to)
+		return err
+	}
+	return nil
+}
+
+func (o *atomicOutput) abort() {
+	_ = os.Remove(o.tmp)
+}
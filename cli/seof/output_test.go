@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomicOutput_NoClobber(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out")
+	ao, err := newAtomicOutput(name, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(ao.tmp, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(name, []byte("created meanwhile"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ao.commit(); err == nil {
+		t.Fatal("an existing file should not be overwritten without -force")
+	}
+	if b, _ := os.ReadFile(name); string(b) != "created meanwhile" {
+		t.Fatal(string(b))
+	}
+	if _, err = os.Stat(ao.tmp); !os.IsNotExist(err) {
+		t.Fatal("the temporary file should be removed")
+	}
+
+	if _, err = newAtomicOutput(name, false); err == nil {
+		t.Fatal("an existing file should not be overwritten without -force")
+	}
+	ao, err = newAtomicOutput(name, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(ao.tmp, []byte("forced"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ao.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "forced" {
+		t.Fatal(string(b))
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(name)); len(entries) != 1 {
+		t.Fatal("only the output should be left", entries)
+	}
+}
+
+func TestRenameNoReplace(t *testing.T) {
+	for _, rename := range []func(string, string) error{renameNoReplace, linkNoReplace, createNoReplace} {
+		dir := t.TempDir()
+		from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+		if err := os.WriteFile(from, []byte("renamed"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := rename(from, to); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := os.ReadFile(to); string(b) != "renamed" {
+			t.Fatal(string(b))
+		}
+		if _, err := os.Stat(from); !os.IsNotExist(err) {
+			t.Fatal("the source should be gone")
+		}
+
+		if err := os.WriteFile(from, []byte("new"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := rename(from, to); err == nil {
+			t.Fatal("an existing file should not be overwritten")
+		}
+		if b, _ := os.ReadFile(to); string(b) != "renamed" {
+			t.Fatal(string(b))
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/kuking/seof"
+	"golang.org/x/term"
+)
+
+// Passwords can be read from a file, a file descriptor, an environment variable, the output of a command or, when none
+// is given, prompted in the terminal. A single trailing newline ("\n" or "\r\n") is removed from files, descriptors and
+// commands output as editors and `echo` add one; -pass-raw uses the bytes as they are, as previous versions did. Files
+// encrypted by previous versions with a password file ending in a newline are still opened: when the trimmed password
+// is wrong, the password as read is tried (see retryRaw).
+
+const maxPasswordLength = 64 * 1024
+
+type passwordSource struct {
+	label string
+	file  *string
+	fd    *int
+	env   *string
+	cmd   *string
+	raw   *bool
+
+	password  []byte    // as read, without the trailing newline unless -pass-raw
+	untrimmed []byte    // as read when a trailing newline was removed, nil otherwise
+	warn      sync.Once // the untrimmed password was right
+}
+
+// passwordFlags registers the password source options, prefix allows a second password (i.e. "new-" for passwd)
+func passwordFlags(fs *flag.FlagSet, prefix string, label string) *passwordSource {
+	return &passwordSource{
+		label: label,
+		file:  fs.String(prefix+"p", "", label+" file (@ prefix optional)"),
+		fd:    fs.Int(prefix+"pass-fd", -1, label+" read from this open file descriptor"),
+		env:   fs.String(prefix+"pass-env", "", label+" read from this environment variable"),
+		cmd:   fs.String(prefix+"pass-cmd", "", label+" read from this command output, i.e. \"pass show backup\""),
+		raw:   fs.Bool(prefix+"pass-raw", false, label+" used as read, without removing a trailing newline"),
+	}
+}
+
+// read returns the password from the given source, prompting in the terminal when none was given; confirm asks twice
+func (ps *passwordSource) read(confirm bool) ([]byte, error) {
+	given := 0
+	for _, set := range []bool{*ps.file != "", *ps.fd >= 0, *ps.env != "", *ps.cmd != ""} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return nil, errors.New("only one " + ps.label + " source can be given")
+	}
+
+	var password []byte
+	var err error
+	switch {
+	case *ps.file != "":
+		password, err = readPassword(*ps.file)
+	case *ps.fd >= 0:
+		password, err = readPasswordFd(*ps.fd)
+	case *ps.env != "":
+		value, ok := os.LookupEnv(*ps.env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %v not set", *ps.env)
+		}
+		password = []byte(value)
+	case *ps.cmd != "":
+		password, err = readPasswordCmd(*ps.cmd)
+	default:
+		return promptPassword(ps.label, confirm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := trimNewline(password); !*ps.raw && len(trimmed) != len(password) {
+		ps.untrimmed = password
+		password = trimmed
+	}
+	if len(password) == 0 {
+		return nil, errors.New(ps.label + " is empty")
+	}
+	ps.password = password
+	return password, nil
+}
+
+// checkStdin fails when the password would be read from stdin (-pass-fd 0) and stdin is one of the input files too
+func (ps *passwordSource) checkStdin(files []string) error {
+	if *ps.fd == 0 && slices.Contains(files, "-") {
+		return errors.New("the " + ps.label + " can not be read from stdin (-pass-fd 0), it is an input")
+	}
+	return nil
+}
+
+// retryRaw calls use with the password read and, when it is wrong and a trailing newline was removed from it, with the
+// password as read: previous versions used password files as they are. It warns once to use -pass-raw then.
+func (ps *passwordSource) retryRaw(use func(password []byte) error) error {
+	err := use(ps.password)
+	if ps.untrimmed == nil || !errors.Is(err, seof.ErrWrongPassword) {
+		return err
+	}
+	if err = use(ps.untrimmed); err == nil {
+		ps.warn.Do(func() {
+			_, _ = fmt.Fprintf(os.Stderr, "WARNING: the %v is right with its trailing newline, use -pass-raw\n", ps.label)
+		})
+	}
+	return err
+}
+
+// hintRaw adds a -pass-raw hint to a wrong password error when it can not be retried (i.e. reading stdin)
+func (ps *passwordSource) hintRaw(err error) error {
+	if ps.untrimmed != nil && errors.Is(err, seof.ErrWrongPassword) {
+		return fmt.Errorf("%w (a trailing newline was removed from the %v, -pass-raw keeps it)", err, ps.label)
+	}
+	return err
+}
+
+// openFile opens a seof file with the password, see retryRaw
+func (ps *passwordSource) openFile(name string, flag int) (*seof.File, error) {
+	var ef *seof.File
+	err := ps.retryRaw(func(password []byte) (err error) {
+		ef, err = seof.OpenFile(name, flag, 0, seof.WithPassword(password), seof.WithCacheSize(memoryBuffers))
+		return err
+	})
+	return ef, err
+}
+
+func readPassword(passwordFile string) ([]byte, error) {
+	if passwordFile == "" {
+		return nil, errors.New("password not provided")
+	}
+	if len(passwordFile) > 1 && passwordFile[0] == '@' {
+		passwordFile = passwordFile[1:]
+	}
+	return os.ReadFile(passwordFile)
+}
+
+func readPasswordFd(fd int) ([]byte, error) {
+	file := os.NewFile(uintptr(fd), fmt.Sprintf("fd%v", fd))
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %v", fd)
+	}
+	if fd > 2 { // stdin, stdout and stderr are kept open
+		defer func() { _ = file.Close() }()
+	}
+	return readLimited(file)
+}
+
+func readPasswordCmd(command string) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", command)
+	// never stdin, it might be the data being encrypted; the command can prompt in the terminal
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		defer func() { _ = tty.Close() }()
+		cmd.Stdin = tty
+	}
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	password, readErr := readLimited(stdout)
+	if err = cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("password command failed: %w", err)
+	}
+	return password, readErr
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	password, err := io.ReadAll(io.LimitReader(r, maxPasswordLength+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(password) > maxPasswordLength {
+		return nil, fmt.Errorf("password longer than %v bytes", maxPasswordLength)
+	}
+	return password, nil
+}
+
+func trimNewline(password []byte) []byte {
+	if bytes.HasSuffix(password, []byte("\r\n")) {
+		return password[:len(password)-2]
+	}
+	return bytes.TrimSuffix(password, []byte("\n"))
+}
+
+// promptPassword reads a password from the controlling terminal without echo, stdin and stdout might be carrying data
+func promptPassword(label string, confirm bool) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.New(label + " not provided and there is no terminal to prompt for it")
+	}
+	defer func() { _ = tty.Close() }()
+
+	prompt := strings.ToUpper(label[:1]) + label[1:]
+	_, _ = fmt.Fprintf(tty, "%v: ", prompt)
+	password, err := term.ReadPassword(int(tty.Fd()))
+	_, _ = fmt.Fprintln(tty)
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return nil, errors.New(label + " is empty")
+	}
+	if confirm {
+		_, _ = fmt.Fprintf(tty, "Repeat %v: ", label)
+		again, err := term.ReadPassword(int(tty.Fd()))
+		_, _ = fmt.Fprintln(tty)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(password, again) {
+			return nil, errors.New("passwords do not match")
+		}
+	}
+	return password, nil
+}
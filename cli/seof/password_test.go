@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kuking/seof"
+	"github.com/kuking/seof/crypto"
+)
+
+func givenPasswordSource(t *testing.T, args ...string) *passwordSource {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	ps := passwordFlags(fs, "", "password")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func TestPasswordSource_Env(t *testing.T) {
+	t.Setenv("SEOF_TEST_PASSWORD", "secret\n")
+	if password, err := givenPasswordSource(t, "-pass-env", "SEOF_TEST_PASSWORD").read(false); err != nil || string(password) != "secret" {
+		t.Fatal("a trailing newline should be removed", err)
+	}
+	if password, err := givenPasswordSource(t, "-pass-env", "SEOF_TEST_PASSWORD", "-pass-raw").read(false); err != nil || string(password) != "secret\n" {
+		t.Fatal("-pass-raw should keep the newline", err)
+	}
+	t.Setenv("SEOF_TEST_PASSWORD", "")
+	if _, err := givenPasswordSource(t, "-pass-env", "SEOF_TEST_PASSWORD").read(false); err == nil {
+		t.Fatal("an empty password should fail")
+	}
+	if _, err := givenPasswordSource(t, "-pass-env", "SEOF_TEST_MISSING").read(false); err == nil {
+		t.Fatal("a missing variable should fail")
+	}
+}
+
+func TestPasswordSource_Cmd(t *testing.T) {
+	if password, err := givenPasswordSource(t, "-pass-cmd", "echo secret").read(false); err != nil || string(password) != "secret" {
+		t.Fatal(err)
+	}
+	if _, err := givenPasswordSource(t, "-pass-cmd", "exit 1").read(false); err == nil {
+		t.Fatal("a failing command should fail")
+	}
+}
+
+func TestPasswordSource_RetriesRaw(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile, name := filepath.Join(dir, "password"), filepath.Join(dir, "file.seof")
+	if err := os.WriteFile(passwordFile, []byte(testPassword+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// encrypted by a previous version, the password file used as is
+	ef, err := seof.Create(name, seof.WithPassword([]byte(testPassword+"\n")), seof.WithKDF(crypto.MinSCryptParameters))
+	if err == nil {
+		err = ef.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps := givenPasswordSource(t, "-p", passwordFile)
+	if password, err := ps.read(false); err != nil || string(password) != testPassword {
+		t.Fatal(err)
+	}
+	if ef, err = ps.openFile(name, os.O_RDONLY); err != nil {
+		t.Fatal("the password as read should be tried", err)
+	}
+	_ = ef.Close()
+
+	ps = givenPasswordSource(t, "-p", passwordFile, "-pass-raw")
+	if _, err = ps.read(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ps.openFile(name, os.O_RDONLY); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasswordSource_CheckStdin(t *testing.T) {
+	if err := givenPasswordSource(t, "-pass-fd", "0").checkStdin([]string{"-"}); err == nil {
+		t.Fatal("stdin can not be the password and an input")
+	}
+	if err := givenPasswordSource(t, "-pass-fd", "0").checkStdin([]string{"file"}); err != nil {
+		t.Fatal(err)
+	}
+}
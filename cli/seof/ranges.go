@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// byteRange is a section of the plain content, a negative offset counts from the end (as `tail -c`), a negative length
+// reads until the end.
+type byteRange struct {
+	offset int64
+	length int64
+}
+
+// rangesFlag collects repeated -range offset[:length] values
+type rangesFlag []byteRange
+
+func (rf *rangesFlag) String() string {
+	parts := make([]string, len(*rf))
+	for i, r := range *rf {
+		parts[i] = fmt.Sprintf("%v:%v", r.offset, r.length)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (rf *rangesFlag) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		r := byteRange{length: -1}
+		offset, length, hasLength := strings.Cut(part, ":")
+		var err error
+		if r.offset, err = parseSize(offset); err != nil {
+			return err
+		}
+		if hasLength && length != "" {
+			if r.length, err = parseSize(length); err != nil {
+				return err
+			}
+			if r.length < 0 {
+				return fmt.Errorf("negative length: %v", length)
+			}
+		}
+		*rf = append(*rf, r)
+	}
+	return nil
+}
+
+// parseSize parses a (possibly negative) byte count with an optional K, M, G or T suffix (powers of 1024)
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, errors.New("empty size")
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	case "T":
+		multiplier = 1 << 40
+	}
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %v", value)
+	}
+	if n > math.MaxInt64/multiplier || n < -math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size too large: %v", value)
+	}
+	return n * multiplier, nil
+}
+
+// section resolves the range against the content size, clamping it to the content
+func (r byteRange) section(size int64) (offset int64, length int64) {
+	offset = r.offset
+	if offset < 0 {
+		offset += size
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	if offset > size {
+		offset = size
+	}
+	length = size - offset
+	if r.length >= 0 && r.length < length {
+		length = r.length
+	}
+	return offset, length
+}
+
+// copyRanges writes the ranges of the content in order, only the blocks covering them are read and decrypted
+func copyRanges(w io.Writer, r io.ReaderAt, size int64, ranges []byteRange) error {
+	for _, br := range ranges {
+		offset, length := br.section(size)
+		if _, err := io.Copy(w, io.NewSectionReader(r, offset, length)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
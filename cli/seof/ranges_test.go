@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		expected int64
+		fails    bool
+	}{
+		{value: "0", expected: 0},
+		{value: "123", expected: 123},
+		{value: "-123", expected: -123},
+		{value: " 2k ", expected: 2 << 10},
+		{value: "3M", expected: 3 << 20},
+		{value: "-1G", expected: -1 << 30},
+		{value: "5t", expected: 5 << 40},
+		{value: "9223372036854775807", expected: math.MaxInt64},
+		{value: "8388607T", expected: 8388607 << 40},
+		{value: "8388608T", fails: true},
+		{value: "-8388608T", fails: true},
+		{value: "9223372036854775807K", fails: true},
+		{value: "9223372036854775808", fails: true},
+		{value: "", fails: true},
+		{value: "K", fails: true},
+		{value: "1X", fails: true},
+		{value: "1.5M", fails: true},
+	} {
+		n, err := parseSize(tc.value)
+		if tc.fails != (err != nil) || (!tc.fails && n != tc.expected) {
+			t.Errorf("parseSize(%q) = %v, %v", tc.value, n, err)
+		}
+	}
+}
+
+func TestRangesFlag_Set(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		expected []byteRange
+		fails    bool
+	}{
+		{value: "10", expected: []byteRange{{offset: 10, length: -1}}},
+		{value: "10:", expected: []byteRange{{offset: 10, length: -1}}},
+		{value: "1K:2K", expected: []byteRange{{offset: 1 << 10, length: 2 << 10}}},
+		{value: "-100:0", expected: []byteRange{{offset: -100, length: 0}}},
+		{value: "0:5,-5", expected: []byteRange{{offset: 0, length: 5}, {offset: -5, length: -1}}},
+		{value: "10:-5", fails: true},
+		{value: ":5", fails: true},
+		{value: "1,,2", fails: true},
+		{value: "a:b", fails: true},
+	} {
+		var rf rangesFlag
+		err := rf.Set(tc.value)
+		if tc.fails != (err != nil) {
+			t.Errorf("Set(%q): %v", tc.value, err)
+			continue
+		}
+		if tc.fails {
+			continue
+		}
+		if len(rf) != len(tc.expected) {
+			t.Errorf("Set(%q) = %v", tc.value, rf)
+			continue
+		}
+		for i := range rf {
+			if rf[i] != tc.expected[i] {
+				t.Errorf("Set(%q) = %v", tc.value, rf)
+			}
+		}
+	}
+
+	var rf rangesFlag
+	if rf.Set("1:2") != nil || rf.Set("3") != nil || rf.String() != "1:2,3:-1" {
+		t.Error("repeated flags should be collected", rf.String())
+	}
+}
+
+func TestByteRange_Section(t *testing.T) {
+	for _, tc := range []struct {
+		r              byteRange
+		size           int64
+		offset, length int64
+	}{
+		{r: byteRange{offset: 0, length: -1}, size: 100, offset: 0, length: 100},
+		{r: byteRange{offset: 10, length: 20}, size: 100, offset: 10, length: 20},
+		{r: byteRange{offset: 90, length: 20}, size: 100, offset: 90, length: 10},  // clamped to the end
+		{r: byteRange{offset: 150, length: 20}, size: 100, offset: 100, length: 0}, // past the end
+		{r: byteRange{offset: -10, length: -1}, size: 100, offset: 90, length: 10},
+		{r: byteRange{offset: -10, length: 5}, size: 100, offset: 90, length: 5},
+		{r: byteRange{offset: -150, length: 20}, size: 100, offset: 0, length: 20}, // before the start
+		{r: byteRange{offset: 10, length: 0}, size: 100, offset: 10, length: 0},
+		{r: byteRange{offset: -1, length: -1}, size: 0, offset: 0, length: 0},
+	} {
+		offset, length := tc.r.section(tc.size)
+		if offset != tc.offset || length != tc.length {
+			t.Errorf("%+v.section(%v) = %v, %v", tc.r, tc.size, offset, length)
+		}
+	}
+}
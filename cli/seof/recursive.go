@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Recursive mode mirrors a source directory tree into a destination one. Files are processed concurrently, a file is
+// skipped when its destination has the modification time it is given when written: the source's one when encrypting
+// (recorded in the seof file, so encrypted files copied without preserving times are still unchanged, see dstModTime),
+// the one recorded in the seof file when decrypting
+
+func defaultWorkers() int {
+	// every worker might be deriving an scrypt key, which can take a lot of memory (>512MB with the default parameters):
+	// files sharing a password salt (encrypt -r) derive it once, files with their own salt derive one each
+	if runtime.NumCPU() < 4 {
+		return runtime.NumCPU()
+	}
+	return 4
+}
+
+type recursiveJob struct {
+	src  string
+	dst  string
+	info os.FileInfo
+}
+
+type recursiveRun struct {
+	srcRoot string
+	dstRoot string
+	workers int
+	follow  bool
+	force   bool
+	// target returns the destination path relative to dstRoot, or false if the file should be skipped
+	target func(rel string) (string, bool)
+	// open opens src once for modTime and process (i.e. deriving a seof file key only once), nil when not needed
+	open func(src string) (io.Closer, error)
+	// process writes src into dst, with the modification time returned by modTime; opened is what open returned
+	process func(src string, opened io.Closer, dst string, info os.FileInfo) error
+	// modTime returns the modification time dst is given when written
+	modTime func(src string, opened io.Closer, info os.FileInfo) (time.Time, error)
+	// dstModTime returns the modification time an existing dst was given when written, nil for its filesystem one
+	dstModTime func(dst string, dstInfo os.FileInfo) (time.Time, error)
+
+	failed    int64
+	processed int64
+	skipped   int64
+	outMutex  sync.Mutex
+}
+
+func (r *recursiveRun) report(format string, args ...interface{}) {
+	r.outMutex.Lock()
+	defer r.outMutex.Unlock()
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+func (r *recursiveRun) fail(path string, err error) {
+	atomic.AddInt64(&r.failed, 1)
+	r.report("%v: %v", path, err)
+}
+
+func (r *recursiveRun) skip(path string, reason string) {
+	atomic.AddInt64(&r.skipped, 1)
+	r.report("%v: skipped, %v", path, reason)
+}
+
+// run walks the source tree, returning the exit code
+func (r *recursiveRun) run() int {
+	srcInfo, err := os.Stat(r.srcRoot)
+	if err != nil || !srcInfo.IsDir() {
+		r.report("%v: not a directory", r.srcRoot)
+		return -1
+	}
+	absDst, _ := filepath.Abs(r.dstRoot)
+	if r.workers < 1 {
+		r.workers = 1
+	}
+
+	jobs := make(chan recursiveJob)
+	var wg sync.WaitGroup
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				r.runJob(job)
+			}
+		}()
+	}
+
+	err = filepath.WalkDir(r.srcRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			r.fail(path, err)
+			return nil
+		}
+		if d.IsDir() {
+			if abs, _ := filepath.Abs(path); abs == absDst {
+				return filepath.SkipDir // destination within the source tree
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			r.fail(path, err)
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !r.follow {
+				r.skip(path, "symbolic link (use -L to follow)")
+				return nil
+			}
+			if info, err = os.Stat(path); err != nil {
+				r.fail(path, err)
+				return nil
+			}
+			if info.IsDir() {
+				r.skip(path, "symbolic link to a directory")
+				return nil
+			}
+		}
+		if !info.Mode().IsRegular() {
+			r.skip(path, "not a regular file ("+info.Mode().Type().String()+")")
+			return nil
+		}
+		rel, err := filepath.Rel(r.srcRoot, path)
+		if err != nil {
+			r.fail(path, err)
+			return nil
+		}
+		dstRel, ok := r.target(rel)
+		if !ok {
+			r.skip(path, "not a seof file")
+			return nil
+		}
+		jobs <- recursiveJob{src: path, dst: filepath.Join(r.dstRoot, dstRel), info: info}
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		r.fail(r.srcRoot, err)
+	}
+
+	r.report("%v files processed, %v skipped, %v failed", r.processed, r.skipped, r.failed)
+	if r.failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (r *recursiveRun) runJob(job recursiveJob) {
+	var opened io.Closer
+	if r.open != nil {
+		var err error
+		if opened, err = r.open(job.src); err != nil {
+			r.fail(job.src, err)
+			return
+		}
+		defer func() { _ = opened.Close() }()
+	}
+	if dstInfo, err := os.Stat(job.dst); err == nil {
+		modTime, err := r.modTime(job.src, opened, job.info)
+		if err != nil {
+			r.fail(job.src, err)
+			return
+		}
+		dstModTime := dstInfo.ModTime()
+		if r.dstModTime != nil {
+			dstModTime, err = r.dstModTime(job.dst, dstInfo)
+		}
+		if err != nil && !r.force { // i.e. another password, overwritten with -force
+			r.fail(job.src, fmt.Errorf("%v: %w (use -force to overwrite)", job.dst, err))
+			return
+		}
+		if err == nil && dstModTime.Equal(modTime) {
+			r.skip(job.src, "unchanged")
+			return
+		}
+		if !r.force {
+			r.fail(job.src, errors.New(job.dst+" already exists and differs (use -force to overwrite)"))
+			return
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(job.dst), 0700); err != nil {
+		r.fail(job.src, err)
+		return
+	}
+	if err := r.process(job.src, opened, job.dst, job.info); err != nil {
+		r.fail(job.src, err)
+		return
+	}
+	atomic.AddInt64(&r.processed, 1)
+	r.report("%v -> %v", job.src, job.dst)
+}
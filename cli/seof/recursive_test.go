@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kuking/seof"
+)
+
+const testPassword = "correct horse battery staple 4f760f9e67c61ff6"
+
+func TestRecursive_RoundTripsModTime(t *testing.T) {
+	t.Setenv("SEOF_TEST_PASSWORD", testPassword)
+	dir := t.TempDir()
+	src, enc, dec := filepath.Join(dir, "src"), filepath.Join(dir, "enc"), filepath.Join(dir, "dec")
+	original := time.Date(2020, 2, 3, 4, 5, 6, 7000, time.UTC)
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(src, "sub", "file.txt")
+	if err := os.WriteFile(name, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, original, original); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := cmdEncrypt([]string{"-pass-env", "SEOF_TEST_PASSWORD", "-scrypt", "min", "-r", src, enc}); code != 0 {
+		t.Fatal(code)
+	}
+	encrypted := filepath.Join(enc, "sub", "file.txt.seof")
+	if info, err := os.Stat(encrypted); err != nil || !info.ModTime().Equal(original) {
+		t.Fatal("encrypted file should have the source modification time", err)
+	}
+	later := original.Add(time.Hour) // i.e. copied without preserving times
+	if err := os.Chtimes(encrypted, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	decrypt := []string{"-pass-env", "SEOF_TEST_PASSWORD", "-r", enc, dec}
+	if code := cmdDecrypt(decrypt); code != 0 {
+		t.Fatal(code)
+	}
+	decrypted := filepath.Join(dec, "sub", "file.txt")
+	if info, err := os.Stat(decrypted); err != nil || !info.ModTime().Equal(original) {
+		t.Fatal("decrypted file should have the recorded modification time", err, info.ModTime())
+	}
+	if b, _ := os.ReadFile(decrypted); string(b) != "hello" {
+		t.Fatal(string(b))
+	}
+
+	// unchanged, with the recorded modification time: skipped
+	if err := os.WriteFile(decrypted, []byte("kept"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(decrypted, original, original); err != nil {
+		t.Fatal(err)
+	}
+	if code := cmdDecrypt(decrypt); code != 0 {
+		t.Fatal(code)
+	}
+	if b, _ := os.ReadFile(decrypted); string(b) != "kept" {
+		t.Fatal("unchanged files should be skipped")
+	}
+}
+
+type countingCloser struct{ closed *int }
+
+func (c countingCloser) Close() error {
+	*c.closed++
+	return nil
+}
+
+func TestRecursive_OpensOnce(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	for _, d := range []string{src, dst} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, "file"), []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dst, "file"), []byte("old"), 0600); err != nil { // checked, then overwritten
+		t.Fatal(err)
+	}
+
+	opens, closes := 0, 0
+	run := recursiveRun{
+		srcRoot: src, dstRoot: dst, workers: 1, force: true,
+		target: func(rel string) (string, bool) { return rel, true },
+		open: func(_ string) (io.Closer, error) {
+			opens++
+			return countingCloser{&closes}, nil
+		},
+		process: func(src string, opened io.Closer, dst string, _ os.FileInfo) error {
+			if opened == nil {
+				t.Error("process should be given the opened source")
+			}
+			b, err := os.ReadFile(src)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(dst, b, 0600)
+		},
+		modTime: func(_ string, opened io.Closer, _ os.FileInfo) (time.Time, error) {
+			if opened == nil {
+				t.Error("modTime should be given the opened source")
+			}
+			return time.Time{}, nil
+		},
+	}
+	if code := run.run(); code != 0 {
+		t.Fatal(code)
+	}
+	if opens != 1 || closes != 1 {
+		t.Fatal("the source should be opened and closed once", opens, closes)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dst, "file")); string(b) != "new" {
+		t.Fatal(string(b))
+	}
+}
+
+func TestRecursive_EncryptDerivesOnce(t *testing.T) {
+	t.Setenv("SEOF_TEST_PASSWORD", testPassword)
+	cache := seof.NewKeyCache(time.Minute)
+	seof.SetKeyCache(cache)
+	defer seof.SetKeyCache(nil)
+	dir := t.TempDir()
+	src, enc := filepath.Join(dir, "src"), filepath.Join(dir, "enc")
+	if err := os.MkdirAll(src, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encrypt := []string{"-pass-env", "SEOF_TEST_PASSWORD", "-scrypt", "min", "-j", "1", "-r", src, enc}
+	if code := cmdEncrypt(encrypt); code != 0 {
+		t.Fatal(code)
+	}
+	if cache.Len() != 1 {
+		t.Fatal("the files share a password salt, one key should be derived", cache.Len())
+	}
+
+	// copied without preserving times: the recorded modification time is still the source one
+	later := time.Now().Add(time.Hour)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.Chtimes(filepath.Join(enc, name+seofSuffix), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code := cmdEncrypt(encrypt); code != 0 {
+		t.Fatal("unchanged files should be skipped", code)
+	}
+	if info, _ := os.Stat(filepath.Join(enc, "a"+seofSuffix)); !info.ModTime().Equal(later) {
+		t.Fatal("should not be encrypted again")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func renameNoReplace(from, to string) error {
+	return linkNoReplace(from, to)
+}
@@ -2,70 +2,113 @@ package main
 
 import (
 	"encoding/hex"
-	"flag"
 	"fmt"
-	"io"
+	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	pwe "github.com/kuking/go-pwentropy"
 	"github.com/kuking/seof"
 	"github.com/kuking/seof/crypto"
 )
 
-var doEncrypt bool
-var passwordFile string
-var doHelp bool
-var doInfo bool
-var blockSize uint
-var scryptParamsCli string
-
-func doArgsParsing() bool {
-	flag.BoolVar(&doEncrypt, "e", false, "encrypt (default: to decrypt)")
-	flag.StringVar(&scryptParamsCli, "scrypt", "default", "Encrypting Scrypt parameters: min, default, better, max")
-	flag.BoolVar(&doInfo, "i", false, "show seof encrypted file metadata")
-	flag.StringVar(&passwordFile, "p", "", "password file")
-	flag.UintVar(&blockSize, "s", 1024, "block size")
-	flag.BoolVar(&doHelp, "h", false, "Show usage")
-	flag.Parse()
-	if doHelp || flag.NArg() != 1 {
-		fmt.Printf("Usage of %v: seof file utility\n\n", os.Args[0])
-		flag.PrintDefaults()
-		fmt.Print(`
-NOTES: 
-  - Password must be provided in a file. Command line is not secure in a multi-user host.
-  - When encrypting, contents have to be provided via stdin pipe, decrypted output will be via stdout.
-  - Scrypt parameters target times in modern CPUs (2021): min>20ms, default>600ms, better>5s, max>9s
-
-Examples: 
-  $ cat file | seof -e -p @password_file file.seof
-  $ seof -p @password_file file.seof > file
-  $ seof -i -p @password_file file.seof 
-`)
-		return false
-	}
-	return true
+type command struct {
+	run   func(args []string) int
+	usage string
 }
 
-func main() {
+var commands = map[string]command{
+	"encrypt": {cmdEncrypt, "encrypts files: file -> file.seof"},
+	"decrypt": {cmdDecrypt, "decrypts files: file.seof -> file"},
+	"info":    {cmdInfo, "shows seof encrypted files metadata"},
+	"cat":     {cmdCat, "decrypts files to stdout"},
+	"verify":  {cmdVerify, "reads and authenticates every block in the files"},
+	"passwd":  {cmdPasswd, "changes the password of encrypted files (re-encrypting them)"},
+	"agent":   {cmdAgent, "keeps derived keys for other invocations, in $SEOF_AGENT_SOCK"},
+}
 
-	if !doArgsParsing() {
-		os.Exit(-1)
+func usage() {
+	fmt.Printf("Usage of %v: seof file utility\n\n", os.Args[0])
+	fmt.Printf("  %v <command> [options] <files...>\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
 	}
-
-	if passwordFile == "" {
-		_, _ = os.Stderr.WriteString("password not provided.\n")
-		os.Exit(-1)
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-8v %v\n", name, commands[name].usage)
 	}
+	fmt.Print(`
+Use "seof <command> -h" for the command options. Options go before the files.
 
-	if len(passwordFile) > 1 && passwordFile[0] == '@' {
-		passwordFile = passwordFile[1:]
-	}
+NOTES:
+  - Password is read from a file (-p), descriptor (-pass-fd), variable (-pass-env), command (-pass-cmd) or prompted.
+    Command line is not secure in a multi-user host. A trailing newline is removed unless -pass-raw (files encrypted
+    by previous versions with it are opened too, after warning).
+  - Outputs are written in a temporary file renamed when completed, existing files are not overwritten unless -force.
+  - Key derivation with scrypt (default) or Argon2id (-kdf argon2id), Argon2id presets: min (19MB, 2 passes),
+    default (64MB, 3 passes, 4 threads), better (2GB, 1 pass, 4 threads), max or time:memory:threads (i.e. 3:256M:4).
+  - Scrypt parameters target times in modern CPUs (2021): min>20ms, default>600ms, better>5s, max>9s, or calibrated
+    in this host with auto:time[:max memory] (i.e. auto:1s or auto:2s:256M, default max memory 1G).
+  - With parity, damaged blocks are repaired when read, i.e. 16:2 repairs up to 2 damaged blocks in every 16.
+    Repaired blocks are written back by verify -repair, the other commands do not modify their inputs.
+  - Recursive (-r) mirrors a directory tree, files with the same modification time in the destination are skipped
+    (encrypting, the one recorded in the encrypted files). encrypt -r uses -shared-salt, unless -shared-salt=false.
+  - encrypt -o - streams to stdout (no temporary files, no parity), the stream is a read only seof file.
+    decrypt - reads sequentially from stdin, the output is not complete until the end is verified.
+  - Salts are random, every file derives its own key, unless encrypted with -shared-salt: files encrypted meanwhile
+    with the same password share a password salt, and the key is derived once for all of them (decrypting too).
+  - The agent keeps derived keys and password salts for -ttl, the commands use it when $SEOF_AGENT_SOCK is set.
+    Passwords are sent to the agent, in a socket only accessible to the user.
+  - Without a command, it works as previous versions: seof [-e] [-i] -p @password_file file.seof
 
-	password, err := os.ReadFile(passwordFile)
-	if err != nil {
-		panic(err)
+Examples:
+  $ seof encrypt -p @password_file file1 file2
+  $ seof encrypt -pass-cmd "pass show backup" file1
+  $ seof encrypt -kdf argon2id -argon2id 3:256M:4 -p @password_file file1
+  $ seof decrypt -p @password_file -restore-meta file1.seof file2.seof
+  $ tar c dir | seof encrypt -p @password_file -o dir.tar.seof -
+  $ tar c dir | seof encrypt -p @password_file -o - - | ssh host 'cat > dir.tar.seof'
+  $ curl -s https://host/dir.tar.seof | seof decrypt -p @password_file -o - - | tar x
+  $ seof encrypt -r -p @password_file photos/ backup/photos/
+  $ seof decrypt -r -p @password_file backup/photos/ photos/
+  $ seof cat -p @password_file file.seof | less
+  $ seof cat -p @password_file -offset -10M app.log.seof
+  $ seof info -p @password_file file.seof
+  $ seof verify -p @password_file *.seof
+  $ seof passwd -p @password_file -new-p @new_password_file file.seof
+  $ seof agent -ttl 1h > ~/.seof-agent & sleep 1; . ~/.seof-agent
+  $ seof agent -purge
+`)
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			var local *seof.KeyCache
+			if os.Args[1] != "agent" {
+				local = useKeyCache()
+			}
+			code := cmd.run(os.Args[2:])
+			if local != nil {
+				local.Purge()
+			}
+			os.Exit(code)
+		}
+		if os.Args[1] == "help" {
+			usage()
+			os.Exit(0)
+		}
 	}
+	useKeyCache()
+	legacyMain()
+}
 
+// enoughEntropy checks a password to be used for encrypting, suggesting a good one if not
+func enoughEntropy(password []byte) bool {
 	entropy := pwe.FairEntropy(string(password))
 	if entropy < 96 {
 		_, _ = os.Stderr.WriteString(fmt.Sprintf("FATAL: Est. entropy for provided password is not enough: %2.2f (minimum: 96)\n\n", entropy))
@@ -75,51 +118,154 @@ func main() {
 			"+-------------------------------------------------------+\n"+
 			"| %52v  |\n"+
 			"+-------------------------------------------------------+\n", entropy, password))
-		os.Exit(-1)
+		return false
 	}
+	return true
+}
 
-	var scryptParams crypto.SCryptParameters
-	if doEncrypt {
-		switch scryptParamsCli {
-		case "min":
-			scryptParams = crypto.MinSCryptParameters
-		case "default":
-			scryptParams = crypto.RecommendedSCryptParameters
-		case "better":
-			scryptParams = crypto.BetterSCryptParameters
-		case "max":
-			scryptParams = crypto.MaxSCryptParameters
-		default:
-			fmt.Println("SCrypt parameter not recognised:", scryptParamsCli)
-			os.Exit(-1)
+func parseScrypt(value string) (crypto.SCryptParameters, error) {
+	switch value {
+	case "min":
+		return crypto.MinSCryptParameters, nil
+	case "default":
+		return crypto.RecommendedSCryptParameters, nil
+	case "better":
+		return crypto.BetterSCryptParameters, nil
+	case "max":
+		return crypto.MaxSCryptParameters, nil
+	}
+	if strings.HasPrefix(value, "auto:") {
+		return calibrateScrypt(strings.TrimPrefix(value, "auto:"))
+	}
+	return crypto.SCryptParameters{}, fmt.Errorf("SCrypt parameter not recognised: %v", value)
+}
+
+func parseKDF(kdf string, scryptValue string, argon2idValue string) (crypto.KDFParameters, error) {
+	switch kdf {
+	case "scrypt":
+		return parseScrypt(scryptValue)
+	case "argon2id":
+		return parseArgon2id(argon2idValue)
+	default:
+		return nil, fmt.Errorf("key derivation function not recognised: %v", kdf)
+	}
+}
+
+// parseArgon2id parses a preset or time:memory:threads, i.e. 3:64M:4
+func parseArgon2id(value string) (crypto.Argon2idParameters, error) {
+	switch value {
+	case "min":
+		return crypto.MinArgon2idParameters, nil
+	case "default":
+		return crypto.RecommendedArgon2idParameters, nil
+	case "better":
+		return crypto.BetterArgon2idParameters, nil
+	case "max":
+		return crypto.MaxArgon2idParameters, nil
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return crypto.Argon2idParameters{}, fmt.Errorf("Argon2id parameter not recognised: %v", value)
+	}
+	passes, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return crypto.Argon2idParameters{}, fmt.Errorf("Argon2id time not recognised: %v", parts[0])
+	}
+	memory, err := parseSize(parts[1])
+	if err != nil || memory < 1024 || memory>>10 > math.MaxUint32 {
+		return crypto.Argon2idParameters{}, fmt.Errorf("Argon2id memory not recognised: %v", parts[1])
+	}
+	threads, err := strconv.ParseUint(parts[2], 10, 8)
+	if err != nil {
+		return crypto.Argon2idParameters{}, fmt.Errorf("Argon2id threads not recognised: %v", parts[2])
+	}
+	params := crypto.Argon2idParameters{Time: uint32(passes), Memory: uint32(memory >> 10), Threads: uint8(threads)}
+	return params, params.Verify()
+}
+
+// calibrateScrypt parses time[:max memory] (i.e. 1s:512M, default memory 1G) and calibrates scrypt in this host. The
+// memory limit matters, decrypting needs the same memory in the host doing it.
+func calibrateScrypt(value string) (crypto.SCryptParameters, error) {
+	target, memory, _ := strings.Cut(value, ":")
+	duration, err := time.ParseDuration(target)
+	if err != nil {
+		return crypto.SCryptParameters{}, fmt.Errorf("SCrypt auto target time not recognised: %v", err)
+	}
+	maxMemory := int64(1 << 30)
+	if memory != "" {
+		if maxMemory, err = parseSize(memory); err != nil || maxMemory <= 0 {
+			return crypto.SCryptParameters{}, fmt.Errorf("SCrypt auto maximum memory not recognised: %v", memory)
 		}
+	}
+	params, err := crypto.CalibrateSCrypt(duration, uint64(maxMemory))
+	if err != nil {
+		return params, err
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "SCrypt calibrated: N=%v, R=%v, P=%v (%vMB)\n", params.N, params.R, params.P, params.Memory()>>20)
+	return params, nil
+}
+
+func parseParity(value string) (seof.ParityParameters, error) {
+	if value == "" {
+		return seof.NoParity, nil
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return seof.NoParity, fmt.Errorf("expected data:parity, got %v", value)
+	}
+	data, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return seof.NoParity, err
+	}
+	parityBlocks, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		return seof.NoParity, err
+	}
+	parity := seof.ParityParameters{Data: uint8(data), Parity: uint8(parityBlocks)}
+	return parity, parity.Verify()
+}
 
+func printInfo(ef *seof.File) error {
+	stats, err := ef.Stat()
+	if err != nil {
+		return err
 	}
 
-	filename := os.Args[len(os.Args)-1]
-	var ef *seof.File
-	if doInfo || !doEncrypt {
-		ef, err = seof.OpenExt(filename, password, 10)
+	fmt.Printf("           File Name: %v\n", stats.Name())
+	fmt.Printf("   Modification Time: %v\n", stats.ModTime())
+	fmt.Printf("           File Mode: %v \n", stats.Mode())
+	fmt.Printf("        Content Size: %v bytes\n", stats.Size())
+	fmt.Printf("   File Size On Disk: %v bytes\n", stats.EncryptedSize())
+	fmt.Printf(" Encryption Overhead: %2.2f%%\n", float32(stats.EncryptedSize())*100/float32(stats.Size())-100)
+	fmt.Printf("  Content Block Size: %v bytes\n", stats.BEBlockSize())
+	fmt.Printf("Encrypted Block Size: %v bytes\n", stats.DiskBlockSize())
+	printMeta(ef)
+	fmt.Printf(" Total Blocks Writen: %v (= unique nonces)\n", stats.BlocksWritten())
+	if stats.Parity().Enabled() {
+		fmt.Printf("       Parity Blocks: %v for every %v blocks\n", stats.Parity().Parity, stats.Parity().Data)
+	} else {
+		fmt.Printf("       Parity Blocks: none\n")
+	}
+	salt, n, r, p := stats.SCryptParameters()
+	if argon2id, ok := stats.KDF().(crypto.Argon2idParameters); ok {
+		var argon2idLevel string
+		switch argon2id {
+		case crypto.MinArgon2idParameters:
+			argon2idLevel = "Minimal"
+		case crypto.RecommendedArgon2idParameters:
+			argon2idLevel = "Recommended"
+		case crypto.BetterArgon2idParameters:
+			argon2idLevel = "Better"
+		case crypto.MaxArgon2idParameters:
+			argon2idLevel = "Maximum"
+		default:
+			argon2idLevel = "Custom"
+		}
+		fmt.Printf("     Argon2id Preset: %v\n", argon2idLevel)
+		fmt.Printf(" Argon2id Parameters: time=%v, memory=%vKiB, threads=%v, keyLength=96, salt=\n",
+			argon2id.Time, argon2id.Memory, argon2id.Threads)
 	} else {
-		ef, err = seof.CreateExt(filename, password, scryptParams, int(blockSize), 10)
-	}
-	assertNoError(err, "Failed to open file: "+filename+" -- %v")
-
-	if doInfo {
-		stats, err := ef.Stat()
-		assertNoError(err, "FATAL: problems doing file stats %v")
-
-		fmt.Printf("           File Name: %v\n", stats.Name())
-		fmt.Printf("   Modification Time: %v\n", stats.ModTime())
-		fmt.Printf("           File Mode: %v \n", stats.Mode())
-		fmt.Printf("        Content Size: %v bytes\n", stats.Size())
-		fmt.Printf("   File Size On Disk: %v bytes\n", stats.EncryptedSize())
-		fmt.Printf(" Encryption Overhead: %2.2f%%\n", float32(stats.EncryptedSize())*100/float32(stats.Size())-100)
-		fmt.Printf("  Content Block Size: %v bytes\n", stats.BEBlockSize())
-		fmt.Printf("Encrypted Block Size: %v bytes\n", stats.DiskBlockSize())
-		fmt.Printf(" Total Blocks Writen: %v (= unique nonces)\n", stats.BlocksWritten())
 		var scryptLevel string
-		salt, n, r, p := stats.SCryptParameters()
 		if n == crypto.MinSCryptParameters.N && r == crypto.MinSCryptParameters.R && p == crypto.MinSCryptParameters.P {
 			scryptLevel = "Minimal (>20ms)"
 		} else if n == crypto.MaxSCryptParameters.N && r == crypto.MaxSCryptParameters.R && p == crypto.MaxSCryptParameters.P {
@@ -133,18 +279,10 @@ func main() {
 		}
 		fmt.Printf("       SCrypt Preset: %v\n", scryptLevel)
 		fmt.Printf("   SCrypt Parameters: N=%v, R=%v, P=%v, keyLength=96, salt=\n", n, r, p)
-		hexa := hex.EncodeToString(salt)
-		fmt.Printf("%69v\n%69v\n%69v\n", hexa[:64], hexa[64:128], hexa[128:])
-
-	} else if doEncrypt {
-		_, err = io.Copy(ef, os.Stdin)
-	} else {
-		_, err = io.Copy(os.Stdout, ef)
 	}
-	assertNoError(err, "FATAL: io error: %v")
-
-	err = ef.Close()
-	assertNoError(err, "FATAL: could not close the seof file: %v")
+	hexa := hex.EncodeToString(salt)
+	fmt.Printf("%69v\n%69v\n%69v\n", hexa[:64], hexa[64:128], hexa[128:])
+	return nil
 }
 
 func assertNoError(err error, pattern string) {
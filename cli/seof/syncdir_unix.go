@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import "os"
+
+// syncDir syncs a directory, so the renames in it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
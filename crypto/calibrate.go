@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// SCrypt calibration: the derivation cost grows linearly with N*R (and so does its memory, 128*N*R bytes), the host is
+// measured with small parameters and the cheapest N, R (powers of two within the accepted bounds) estimated to take at
+// least the target time is picked. P is kept at 1, more parallelism does not add memory hardness.
+
+// Memory returns the bytes needed for deriving a key with these parameters
+func (p SCryptParameters) Memory() uint64 {
+	return 128 * uint64(p.N) * uint64(p.R) * uint64(p.P)
+}
+
+// CalibrateSCrypt returns the parameters taking at least target to derive a key in this host, without needing more
+// than maxMemory bytes (0 for no limit other than MaxSCryptParameters). When the target can not be reached within the
+// limits, the most expensive parameters allowed are returned.
+func CalibrateSCrypt(target time.Duration, maxMemory uint64) (SCryptParameters, error) {
+	if maxMemory != 0 && maxMemory < MinSCryptParameters.Memory() {
+		return SCryptParameters{}, errors.New("scrypt: maximum memory lower than the minimum parameters need")
+	}
+	perUnit, err := measureSCrypt(MinSCryptParameters, 100*time.Millisecond)
+	if err != nil {
+		return SCryptParameters{}, err
+	}
+	params := pickSCrypt(target, perUnit, maxMemory)
+
+	if params == MinSCryptParameters || estimate(params, perUnit) < target {
+		return params, nil // cheapest, or the most expensive allowed
+	}
+	// larger parameters do not scale perfectly (memory caches), the pick is re-estimated with its own measurement
+	if perUnit, err = measureSCrypt(params, 0); err != nil {
+		return SCryptParameters{}, err
+	}
+	return pickSCrypt(target, perUnit, maxMemory), nil
+}
+
+// measureSCrypt returns the time per N*R unit, repeating the derivation for at least minimum
+func measureSCrypt(params SCryptParameters, minimum time.Duration) (time.Duration, error) {
+	password := []byte("calibration")
+	salt := RandBytes(96)
+	start := time.Now()
+	count := 0
+	for count == 0 || time.Since(start) < minimum {
+		if _, err := scrypt.Key(password, salt, int(params.N), int(params.R), int(params.P), 96); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return time.Since(start) / time.Duration(uint64(count)*uint64(params.N)*uint64(params.R)), nil
+}
+
+func pickSCrypt(target time.Duration, perUnit time.Duration, maxMemory uint64) SCryptParameters {
+	if perUnit <= 0 {
+		perUnit = 1
+	}
+	best := MinSCryptParameters
+	for r := MinSCryptParameters.R; r <= MaxSCryptParameters.R; r <<= 1 {
+		for n := MinSCryptParameters.N; n <= MaxSCryptParameters.N; n <<= 1 {
+			candidate := SCryptParameters{N: n, R: r, P: 1}
+			if maxMemory != 0 && candidate.Memory() > maxMemory {
+				break
+			}
+			cost := uint64(n) * uint64(r)
+			bestCost := uint64(best.N) * uint64(best.R)
+			reaches := estimate(candidate, perUnit) >= target
+			bestReaches := estimate(best, perUnit) >= target
+			switch {
+			case reaches && (!bestReaches || cost < bestCost):
+				best = candidate
+			case !reaches && !bestReaches && cost > bestCost:
+				best = candidate
+			case cost == bestCost && n > best.N:
+				// same time and memory, a larger N is preferred
+				best = candidate
+			}
+		}
+	}
+	return best
+}
+
+func estimate(params SCryptParameters, perUnit time.Duration) time.Duration {
+	return time.Duration(uint64(params.N)*uint64(params.R)) * perUnit
+}
@@ -0,0 +1,42 @@
+package crypto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalibrateSCrypt(t *testing.T) {
+	params, err := CalibrateSCrypt(time.Millisecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != MinSCryptParameters {
+		t.Errorf("a tiny target should give the minimum parameters, got %v", params)
+	}
+
+	params, err = CalibrateSCrypt(time.Hour, 64*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Memory() > 64*1024*1024 || params.Memory() < 32*1024*1024 {
+		t.Errorf("expected the most expensive parameters within 64MB, got %v (%v bytes)", params, params.Memory())
+	}
+	if params.P != 1 || params.N < MinSCryptParameters.N || params.R < MinSCryptParameters.R {
+		t.Errorf("parameters out of bounds: %v", params)
+	}
+
+	if _, err = CalibrateSCrypt(time.Second, 1024); err == nil {
+		t.Error("should fail with less memory than the minimum parameters need")
+	}
+}
+
+func TestPickSCrypt(t *testing.T) {
+	// 1ns per N*R unit: 2^14*4 = 65µs ... 2^18*256 = 67ms
+	params := pickSCrypt(time.Millisecond, time.Nanosecond, 0)
+	if estimate(params, time.Nanosecond) < time.Millisecond || params.N != 1<<18 || params.R != 4 {
+		t.Errorf("expected the cheapest parameters reaching the target, preferring larger N, got %v", params)
+	}
+	if params = pickSCrypt(time.Hour, time.Nanosecond, 0); params != MaxSCryptParameters {
+		t.Errorf("expected maximum parameters, got %v", params)
+	}
+}
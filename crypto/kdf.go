@@ -0,0 +1,149 @@
+package crypto
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDFParameters derive the master key from a password and salt, the parameters are stored with the file
+type KDFParameters interface {
+	DeriveKey(password []byte, salt []byte, keyLen int) ([]byte, error)
+	// Verify checks the parameters are within the accepted bounds, see Min and Max presets
+	Verify() error
+}
+
+func (p SCryptParameters) DeriveKey(password []byte, salt []byte, keyLen int) ([]byte, error) {
+	return scrypt.Key(password, salt, int(p.N), int(p.R), int(p.P), keyLen)
+}
+
+// DeriveKeyContext is DeriveKey stopping when ctx is done
+func (p SCryptParameters) DeriveKeyContext(ctx context.Context, password []byte, salt []byte, keyLen int) ([]byte, error) {
+	return scryptKey(ctx, password, salt, int(p.N), int(p.R), int(p.P), keyLen)
+}
+
+func (p SCryptParameters) Verify() error {
+	if p.N > MaxSCryptParameters.N || p.N < MinSCryptParameters.N ||
+		p.R > MaxSCryptParameters.R || p.R < MinSCryptParameters.R ||
+		p.P > MaxSCryptParameters.P || p.P < MinSCryptParameters.P {
+		return errors.New("invalid scrypt parameters")
+	}
+	return nil
+}
+
+// DeriveKeyContext derives a key like kdf.DeriveKey in its own goroutine, returning ctx.Err() as soon as ctx is done.
+// scrypt stops deriving, Argon2id (golang.org/x/crypto/argon2 can not be stopped) completes in the background keeping
+// its memory and CPU until then, the key is wiped.
+func DeriveKeyContext(ctx context.Context, kdf KDFParameters, password []byte, salt []byte, keyLen int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		key []byte
+		err error
+	}
+	password = append([]byte{}, password...) // the caller may wipe it while the goroutine runs
+	done := make(chan result, 1)
+	go func() {
+		defer clear(password)
+		var r result
+		if scrypt, ok := kdf.(SCryptParameters); ok {
+			r.key, r.err = scrypt.DeriveKeyContext(ctx, password, salt, keyLen)
+		} else {
+			r.key, r.err = kdf.DeriveKey(password, salt, keyLen)
+		}
+		done <- r
+	}()
+	select {
+	case r := <-done:
+		return r.key, r.err
+	case <-ctx.Done():
+		go func() {
+			r := <-done
+			clear(r.key)
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Argon2id parameters, Memory in KiB. Presets as per RFC 9106 and OWASP recommendations (2023):
+//
+// Minimum accepted: 19MiB and 2 passes, OWASP minimum
+// Currently recommended: 64MiB, 3 passes and 4 lanes, RFC 9106 second recommended option
+// Maximum accepted: an upper limit defined to avoid potential DoS attacks
+type Argon2idParameters struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+var MinArgon2idParameters = Argon2idParameters{
+	Time:    2,
+	Memory:  19 * 1024,
+	Threads: 1,
+}
+
+var RecommendedArgon2idParameters = Argon2idParameters{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+var BetterArgon2idParameters = Argon2idParameters{ // RFC 9106 first recommended option
+	Time:    1,
+	Memory:  2 * 1024 * 1024,
+	Threads: 4,
+}
+
+var MaxArgon2idParameters = Argon2idParameters{
+	Time:    16,
+	Memory:  4 * 1024 * 1024,
+	Threads: 64,
+}
+
+func (p Argon2idParameters) DeriveKey(password []byte, salt []byte, keyLen int) ([]byte, error) {
+	if err := p.Verify(); err != nil {
+		return nil, err
+	}
+	return argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, uint32(keyLen)), nil
+}
+
+func (p Argon2idParameters) Verify() error {
+	if p.Time > MaxArgon2idParameters.Time || p.Time < 1 ||
+		p.Memory > MaxArgon2idParameters.Memory || p.Memory < MinArgon2idParameters.Memory ||
+		p.Threads > MaxArgon2idParameters.Threads || p.Threads < MinArgon2idParameters.Threads ||
+		uint64(p.Time)*uint64(p.Memory) < uint64(MinArgon2idParameters.Time)*uint64(MinArgon2idParameters.Memory) {
+		return errors.New("invalid argon2id parameters")
+	}
+	return nil
+}
+
+// HKDFParameters derive the key from raw key material (i.e. from a KMS) instead of a password, with HKDF-SHA256. The
+// key ID (i.e. of a keyring master key) is bound into the derivation info.
+type HKDFParameters struct {
+	KeyID string
+}
+
+// MinRawKeyLength is the minimum raw key material accepted, 256 bits
+const MinRawKeyLength = 32
+
+const hkdfInfo = "seof raw key"
+
+func (p HKDFParameters) DeriveKey(key []byte, salt []byte, keyLen int) ([]byte, error) {
+	if len(key) < MinRawKeyLength {
+		return nil, errors.New("raw key should be at least 32 bytes long")
+	}
+	info := hkdfInfo
+	if p.KeyID != "" {
+		info += ":" + p.KeyID
+	}
+	return hkdf.Key(sha256.New, key, salt, info, keyLen)
+}
+
+func (p HKDFParameters) Verify() error {
+	return nil
+}
@@ -0,0 +1,99 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestKDFParameters_Presets(t *testing.T) {
+	for _, kdf := range []KDFParameters{
+		MinSCryptParameters, RecommendedSCryptParameters, BetterSCryptParameters, MaxSCryptParameters,
+		MinArgon2idParameters, RecommendedArgon2idParameters, BetterArgon2idParameters, MaxArgon2idParameters,
+	} {
+		if err := kdf.Verify(); err != nil {
+			t.Errorf("preset %v should verify: %v", kdf, err)
+		}
+	}
+}
+
+func TestArgon2idParameters_DeriveKey(t *testing.T) {
+	salt := RandBytes(96)
+	key1, err := MinArgon2idParameters.DeriveKey([]byte("some password"), salt, 96)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, _ := MinArgon2idParameters.DeriveKey([]byte("some password"), salt, 96)
+	key3, _ := MinArgon2idParameters.DeriveKey([]byte("other password"), salt, 96)
+	if len(key1) != 96 || !bytes.Equal(key1, key2) || bytes.Equal(key1, key3) {
+		t.Fatal()
+	}
+	if _, err = (Argon2idParameters{Time: 1, Memory: 1024, Threads: 1}).DeriveKey([]byte("pw"), salt, 96); err == nil {
+		t.Fatal("parameters under the minimum should not be used")
+	}
+}
+
+func TestSCryptParameters_DeriveKeyContext(t *testing.T) {
+	salt := RandBytes(96)
+	p := MinSCryptParameters
+	key, err := p.DeriveKeyContext(context.Background(), []byte("some password"), salt, 96)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := scrypt.Key([]byte("some password"), salt, int(p.N), int(p.R), int(p.P), 96)
+	if !bytes.Equal(key, expected) {
+		t.Fatal("should derive the same key as scrypt.Key")
+	}
+
+	// stops deriving when cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = RecommendedSCryptParameters.DeriveKeyContext(ctx, []byte("some password"), salt, 96); err != context.Canceled {
+		t.Fatal(err)
+	}
+}
+
+func TestDeriveKeyContext(t *testing.T) {
+	salt := RandBytes(96)
+	for _, kdf := range []KDFParameters{MinSCryptParameters, MinArgon2idParameters} {
+		key, err := DeriveKeyContext(context.Background(), kdf, []byte("some password"), salt, 96)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := kdf.DeriveKey([]byte("some password"), salt, 96)
+		if !bytes.Equal(key, expected) {
+			t.Fatal(kdf)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err = DeriveKeyContext(ctx, kdf, []byte("some password"), salt, 96); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}
+
+	// contexts that can be cancelled, not cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, kdf := range []KDFParameters{MinSCryptParameters, MinArgon2idParameters} {
+		if _, err := DeriveKeyContext(ctx, kdf, []byte("some password"), salt, 96); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeriveKeyContext_Argon2idCancelled(t *testing.T) {
+	slow := Argon2idParameters{Time: 16, Memory: 64 * 1024, Threads: 1} // seconds, completed in the background
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := DeriveKeyContext(ctx, slow, []byte("some password"), RandBytes(96), 96); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("should return once the deadline passes", time.Since(start))
+	}
+}
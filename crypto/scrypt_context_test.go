@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// RFC 7914 section 12 (the 1 GiB vector is left out)
+var scryptRFC7914Vectors = []struct {
+	password, salt string
+	N, r, p        int
+	output         string
+}{
+	{"", "", 16, 1, 1, `
+		77 d6 57 62 38 65 7b 20 3b 19 ca 42 c1 8a 04 97 f1 6b 48 44 e3 07 4a e8 df df fa 3f ed e2 14 42
+		fc d0 06 9d ed 09 48 f8 32 6a 75 3a 0f c8 1f 17 e8 d3 e0 fb 2e 0d 36 28 cf 35 e2 0c 38 d1 89 06`},
+	{"password", "NaCl", 1024, 8, 16, `
+		fd ba be 1c 9d 34 72 00 78 56 e7 19 0d 01 e9 fe 7c 6a d7 cb c8 23 78 30 e7 73 76 63 4b 37 31 62
+		2e af 30 d9 2e 22 a3 88 6f f1 09 27 9d 98 30 da c7 27 af b9 4a 83 ee 6d 83 60 cb df a2 cc 06 40`},
+	{"pleaseletmein", "SodiumChloride", 16384, 8, 1, `
+		70 23 bd cb 3a fd 73 48 46 1c 06 cd 81 fd 38 eb fd a8 fb ba 90 4f 8e 3e a9 b5 43 f6 54 5d a1 f2
+		d5 43 29 55 61 3f 0f cf 62 d4 97 05 24 2a 9a f9 e6 1e 85 dc 0d 65 1e 40 df cf 01 7b 45 57 58 87`},
+}
+
+func TestScryptKey_RFC7914(t *testing.T) {
+	for _, v := range scryptRFC7914Vectors {
+		expected, err := hex.DecodeString(strings.Join(strings.Fields(v.output), ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := scryptKey(context.Background(), []byte(v.password), []byte(v.salt), v.N, v.r, v.p, len(expected))
+		if err != nil || !bytes.Equal(key, expected) {
+			t.Errorf("N=%v r=%v p=%v: got %x, %v", v.N, v.r, v.p, key, err)
+		}
+	}
+}
+
+func TestScryptKey_SameAsScrypt(t *testing.T) {
+	salt := RandBytes(96)
+	for _, N := range []int{2, 16, 1024} {
+		for _, r := range []int{1, 2, 3, 8} {
+			for _, p := range []int{1, 2, 3} {
+				key, err := scryptKey(context.Background(), []byte("some password"), salt, N, r, p, 96)
+				expected, _ := scrypt.Key([]byte("some password"), salt, N, r, p, 96)
+				if err != nil || !bytes.Equal(key, expected) {
+					t.Errorf("N=%v r=%v p=%v: differs from scrypt.Key, %v", N, r, p, err)
+				}
+			}
+		}
+	}
+}
+
+func TestScryptKey_Invalid(t *testing.T) {
+	for _, v := range []struct{ N, r, p int }{{0, 1, 1}, {1, 1, 1}, {7, 8, 1}, {16, maxInt / 2, maxInt / 2}} {
+		if _, err := scryptKey(context.Background(), []byte("p"), []byte("s"), v.N, v.r, v.p, 32); err == nil {
+			t.Errorf("N=%v r=%v p=%v should fail", v.N, v.r, v.p)
+		}
+	}
+}
@@ -1,28 +1,32 @@
 package crypto
 
 import (
-	"golang.org/x/crypto/scrypt"
 	"testing"
 	"time"
 )
 
+// The parameters are checked for their relative cost, not timed: how long they take depends on the host, and
+// CalibrateSCrypt picks parameters for a target time in it.
 func TestScryptParameters(t *testing.T) {
-	keyLen := 32 * 3
-	password := []byte("some password")
-	salt := RandBytes(keyLen)
-	start := time.Now()
-	count := 2
-	for i := 0; i < count; i++ {
-		_, err := scrypt.Key(password, salt,
-			int(RecommendedSCryptParameters.N), int(RecommendedSCryptParameters.R), int(RecommendedSCryptParameters.P), keyLen)
-		if err != nil {
-			t.Error(err)
+	cost := func(p SCryptParameters) uint64 { return uint64(p.N) * uint64(p.R) * uint64(p.P) }
+	ordered := []SCryptParameters{MinSCryptParameters, RecommendedSCryptParameters, BetterSCryptParameters, MaxSCryptParameters}
+	for i := 1; i < len(ordered); i++ {
+		if cost(ordered[i]) <= cost(ordered[i-1]) {
+			t.Errorf("%v should cost more than %v", ordered[i], ordered[i-1])
 		}
 	}
-	duration := time.Now().Sub(start)
-	scryptMs := duration.Milliseconds() / int64(count)
-	//fmt.Println("Scrypt parameters taking on average in this CPU:", scryptMs, "ms")
-	if scryptMs < 600 {
-		t.Errorf("Scrypt should take at least 600ms, it took %vms -- Time to increase its parameters", scryptMs)
+	// the recommended parameters are ~30 times the minimum (21ms), aiming at >600ms
+	if ratio := cost(RecommendedSCryptParameters) / cost(MinSCryptParameters); ratio < 600/21 {
+		t.Errorf("recommended parameters only %v times the minimum", ratio)
+	}
+	// the calibration picks the same cost for a target in those proportions
+	target := estimate(RecommendedSCryptParameters, time.Nanosecond)
+	if picked := pickSCrypt(target, time.Nanosecond, 0); cost(picked) != cost(RecommendedSCryptParameters) {
+		t.Errorf("calibration picked %v for the recommended parameters' cost", picked)
+	}
+	for _, p := range ordered {
+		if p.N&(p.N-1) != 0 || p.N < 2 || p.R == 0 || p.P == 0 {
+			t.Errorf("invalid parameters %v", p)
+		}
 	}
 }
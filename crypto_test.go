@@ -1,6 +1,7 @@
 package seof
 
 import (
+	"context"
 	"testing"
 
 	"github.com/kuking/seof/crypto"
@@ -9,10 +10,10 @@ import (
 func TestSealOpen(t *testing.T) {
 	f := File{}
 	h := givenValidHeader()
-	_ = f.initialiseCiphers([]byte(password), &h)
+	_ = f.initialiseCiphers(context.Background(), []byte(password), &h)
 
 	plainText := "This is a secret"
-	cipherText, nonce := f.seal([]byte(plainText), 1234)
+	cipherText, nonce, _ := f.seal([]byte(plainText), 1234)
 	recoveredText, err := f.unseal(cipherText, 1234, nonce)
 	if err != nil {
 		t.Fatal(err)
@@ -25,9 +26,9 @@ func TestSealOpen(t *testing.T) {
 func TestSealOpen_InvalidBlockNo(t *testing.T) {
 	f := File{}
 	h := givenValidHeader()
-	_ = f.initialiseCiphers([]byte(password), &h)
+	_ = f.initialiseCiphers(context.Background(), []byte(password), &h)
 	plainText := "This is a secret"
-	cipherText, nonce := f.seal([]byte(plainText), 1234)
+	cipherText, nonce, _ := f.seal([]byte(plainText), 1234)
 	_, err := f.unseal(cipherText, 5432, nonce)
 	if err == nil {
 		t.Fatal(err)
@@ -37,9 +38,9 @@ func TestSealOpen_InvalidBlockNo(t *testing.T) {
 func TestSealOpen_Sizes(t *testing.T) {
 	f := File{}
 	h := givenValidHeader()
-	_ = f.initialiseCiphers([]byte(password), &h)
+	_ = f.initialiseCiphers(context.Background(), []byte(password), &h)
 	plainText := "This is a secret"
-	cipherText, nonce := f.seal([]byte(plainText), 1234)
+	cipherText, nonce, _ := f.seal([]byte(plainText), 1234)
 
 	if len(nonce) != 36 || len(nonce) != nonceSize {
 		t.Fatal("nonce has to be 12*3 bytes")
@@ -54,9 +55,9 @@ func TestSealOpen_Sizes(t *testing.T) {
 func TestSealOpen_AnyByteChangeShouldFail(t *testing.T) {
 	f := File{}
 	h := givenValidHeader()
-	_ = f.initialiseCiphers([]byte(password), &h)
+	_ = f.initialiseCiphers(context.Background(), []byte(password), &h)
 	plainText := "This is a secret"
-	cipherText, nonce := f.seal([]byte(plainText), 1234)
+	cipherText, nonce, _ := f.seal([]byte(plainText), 1234)
 	// cipher-text
 	for i := 0; i < len(cipherText); i++ {
 		orig := cipherText[i]
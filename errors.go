@@ -0,0 +1,65 @@
+package seof
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors can be told apart with errors.Is and errors.As, i.e. a wrong password from a damaged file:
+//
+//	var corrupt *seof.ErrCorruptBlock
+//	switch {
+//	case errors.Is(err, seof.ErrWrongPassword):
+//	case errors.As(err, &corrupt):
+//	}
+
+var (
+	// ErrWrongPassword is returned on open when block zero does not authenticate: the password (or key) is wrong, or
+	// block zero is damaged
+	ErrWrongPassword = errors.New("wrong password or key")
+	// ErrTruncated is returned when a file or stream ends before expected
+	ErrTruncated = errors.New("truncated")
+	// ErrUnsupportedVersion is returned for files using a magic, extension record or key derivation function unknown
+	// to this version
+	ErrUnsupportedVersion = errors.New("unsupported version, written by a newer seof")
+)
+
+// errNotAuthentic is the cause of an ErrCorruptBlock failing authentication
+var errNotAuthentic = errors.New("message authentication failed")
+
+// ErrInvalidHeader is returned when a header or header extension field is not valid
+type ErrInvalidHeader struct {
+	Field string
+}
+
+func (e *ErrInvalidHeader) Error() string {
+	return "header: invalid " + e.Field
+}
+
+// ErrCorruptBlock is returned when a block can not be read or does not authenticate, Err is the cause
+type ErrCorruptBlock struct {
+	BlockNo int64
+	Offset  int64 // where the block is, in disk or in the stream
+	Err     error
+}
+
+func (e *ErrCorruptBlock) Error() string {
+	return fmt.Sprintf("block %v at offset %v: %v", e.BlockNo, e.Offset, e.Err)
+}
+
+func (e *ErrCorruptBlock) Unwrap() error {
+	return e.Err
+}
+
+func unsupportedError(what string) error {
+	return fmt.Errorf("header: %v: %w", what, ErrUnsupportedVersion)
+}
+
+// truncatedError returns ErrTruncated for the io errors of reading past the end
+func truncatedError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package seof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestErrors_WrongPassword(t *testing.T) {
+	for _, parity := range []ParityParameters{NoParity, testParity} {
+		tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+		f, err := CreateExtParity(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, parity, BEBlockSize, 1)
+		assertNoErr(err, t)
+		assertNoErr(f.Close(), t)
+
+		_, err = OpenExt(tempFile.Name(), []byte("wrong password"), 1)
+		if !errors.Is(err, ErrWrongPassword) {
+			t.Fatal(err)
+		}
+		_, err = NewStreamReader(tempFile, []byte("wrong password"))
+		if !errors.Is(err, ErrWrongPassword) {
+			t.Fatal(err)
+		}
+		deferredCleanup(tempFile)
+	}
+}
+
+func TestErrors_CorruptBlock(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 1)
+	assertNoErr(err, t)
+	_, err = f.Write(crypto.RandBytes(BEBlockSize * 3))
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	offset := f.blockOffset(2)
+	flipByte(t, tempFile.Name(), offset+100)
+
+	f, err = OpenExt(tempFile.Name(), []byte(password), 1)
+	assertNoErr(err, t)
+	_, err = io.ReadAll(f)
+	var corrupt *ErrCorruptBlock
+	if !errors.As(err, &corrupt) || corrupt.BlockNo != 2 || corrupt.Offset != offset || errors.Is(err, ErrWrongPassword) {
+		t.Fatal(err)
+	}
+	if err = f.Verify(); !errors.As(err, &corrupt) || corrupt.BlockNo != 2 {
+		t.Fatal(err)
+	}
+	assertNoErr(f.Close(), t)
+
+	_, err = io.ReadAll(mustStreamReader(t, tempFile))
+	if !errors.As(err, &corrupt) || corrupt.BlockNo != 2 || corrupt.Offset != offset {
+		t.Fatal(err)
+	}
+}
+
+func TestErrors_Truncated(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 1)
+	assertNoErr(err, t)
+	_, err = f.Write(crypto.RandBytes(BEBlockSize * 3))
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	assertNoErr(os.Truncate(tempFile.Name(), f.blockOffset(3)+50), t)
+
+	f, err = OpenExt(tempFile.Name(), []byte(password), 1)
+	assertNoErr(err, t)
+	_, err = io.ReadAll(f)
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatal(err)
+	}
+	assertNoErr(f.Close(), t)
+	if _, err = io.ReadAll(mustStreamReader(t, tempFile)); !errors.Is(err, ErrTruncated) {
+		t.Fatal(err)
+	}
+
+	assertNoErr(os.Truncate(tempFile.Name(), int64(HeaderLength)-1), t)
+	if _, err = OpenExt(tempFile.Name(), []byte(password), 1); err != ErrTruncated {
+		t.Fatal(err)
+	}
+}
+
+func TestErrors_UnsupportedVersion(t *testing.T) {
+	header := givenValidHeader()
+	header.Magic = HeaderMagicExt + 1
+	var invalid *ErrInvalidHeader
+	if err := header.Verify(); !errors.Is(err, ErrUnsupportedVersion) || errors.As(err, &invalid) {
+		t.Fatal(err)
+	}
+	header.Magic = 0x1234
+	if err := header.Verify(); !errors.As(err, &invalid) || invalid.Field != "magic" {
+		t.Fatal(err)
+	}
+
+	for _, raw := range [][]byte{
+		{6, 0, 0, 0, 99, 0, 2, 0, 4, 2}, // unknown record
+		{5, 0, 0, 0, 2, 0, 1, 0, 9},     // unknown key derivation function
+	} {
+		if _, err := HeaderExtFromReader(bytes.NewReader(raw)); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Fatal(err)
+		}
+	}
+	if _, err := HeaderExtFromReader(bytes.NewReader([]byte{5, 0, 0, 0, 1, 0, 1, 0, 4})); !errors.As(err, &invalid) {
+		t.Fatal(err)
+	}
+
+	// a file from the future
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	header.Magic = HeaderMagicExt + 1
+	assertNoErr(binary.Write(tempFile, binary.LittleEndian, header), t)
+	if _, err := OpenExt(tempFile.Name(), []byte(password), 1); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatal(err)
+	}
+}
+
+func flipByte(t *testing.T, name string, offset int64) {
+	disk, err := os.OpenFile(name, os.O_RDWR, 0)
+	assertNoErr(err, t)
+	b := make([]byte, 1)
+	_, err = disk.ReadAt(b, offset)
+	assertNoErr(err, t)
+	_, err = disk.WriteAt([]byte{^b[0]}, offset)
+	assertNoErr(err, t)
+	assertNoErr(disk.Close(), t)
+}
+
+func mustStreamReader(t *testing.T, file *os.File) *StreamReader {
+	in, err := os.Open(file.Name())
+	assertNoErr(err, t)
+	t.Cleanup(func() { _ = in.Close() })
+	r, err := NewStreamReader(in, []byte(password))
+	assertNoErr(err, t)
+	return r
+}
@@ -4,6 +4,11 @@ go 1.24
 
 require (
 	github.com/hashicorp/golang-lru v1.0.2
+	github.com/klauspost/reedsolomon v1.12.4
 	github.com/kuking/go-pwentropy v0.0.0-20200622162422-156827dab9e6
 	golang.org/x/crypto v0.41.0
+	golang.org/x/sys v0.35.0
+	golang.org/x/term v0.34.0
 )
+
+require github.com/klauspost/cpuid/v2 v2.2.8 // indirect
@@ -0,0 +1,57 @@
+package seof
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestCreateExtParity_Argon2id(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	data := crypto.RandBytes(BEBlockSize*3 + 45)
+	f, err := CreateExtParity(tempFile.Name(), []byte(password), crypto.MinArgon2idParameters, NoParity, BEBlockSize, 2)
+	assertNoErr(err, t)
+	_, err = f.Write(data)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	if _, err = OpenExt(tempFile.Name(), []byte("not the right password"), 2); err == nil {
+		t.Fatal("wrong password should fail")
+	}
+	f, err = OpenExt(tempFile.Name(), []byte(password), 2)
+	assertNoErr(err, t)
+	read, err := io.ReadAll(f)
+	assertNoErr(err, t)
+	if !bytes.Equal(data, read) {
+		t.Fatal()
+	}
+	stats, err := f.Stat()
+	assertNoErr(err, t)
+	if stats.KDF() != crypto.MinArgon2idParameters {
+		t.Fatal("expected argon2id parameters, got", stats.KDF())
+	}
+	if _, n, r, p := stats.SCryptParameters(); n != 0 || r != 0 || p != 0 {
+		t.Fatal("scrypt parameters should be zero")
+	}
+	assertNoErr(f.Close(), t)
+}
+
+func TestCreateExtParity_InvalidKDF(t *testing.T) {
+	for _, kdf := range []crypto.KDFParameters{
+		nil,
+		crypto.SCryptParameters{},
+		crypto.Argon2idParameters{},
+		crypto.Argon2idParameters{Time: 1, Memory: crypto.MinArgon2idParameters.Memory, Threads: 1},
+		crypto.Argon2idParameters{Time: 1, Memory: 8 * 1024 * 1024, Threads: 1},
+		crypto.Argon2idParameters{Time: 3, Memory: 64 * 1024, Threads: 0},
+	} {
+		if _, err := CreateExtParity("file", []byte(password), kdf, NoParity, BEBlockSize, 1); err == nil {
+			t.Fatal("key derivation parameters should be checked:", kdf)
+		}
+	}
+}
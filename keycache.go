@@ -0,0 +1,213 @@
+package seof
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kuking/seof/crypto"
+)
+
+// Password salts: every file has its own random salt, a key derived from the password and the file salt is only good for
+// that file. Files created WithPasswordSalt share a password salt instead: the password key is derived from the password
+// and the password salt (the costly scrypt or Argon2id derivation), and every file key from the password key and the
+// file salt with HKDF-SHA256. With a key cache (SetKeyCache), opening or creating many files sharing the password salt
+// derives once. Anyone with the password key can read all of them: it is kept by the cache as any other derived key.
+
+const passwordSaltLength = 32
+const passwordKeyLength = 32
+const passwordKeyInfo = "seof password key"
+
+// KeyDeriver derives keys from passwords, i.e. a KeyCache or a key agent client
+type KeyDeriver interface {
+	DeriveKey(ctx context.Context, kdf crypto.KDFParameters, password []byte, salt []byte, keyLen int) ([]byte, error)
+}
+
+// PasswordSalter gives the password salt for new files, implemented by KeyCache and the key agent client: files
+// created with the same password (and key derivation parameters) meanwhile share it, see PasswordSaltFor
+type PasswordSalter interface {
+	PasswordSalt(ctx context.Context, kdf crypto.KDFParameters, password []byte) ([]byte, error)
+}
+
+var keyCache struct {
+	sync.RWMutex
+	deriver KeyDeriver
+}
+
+// SetKeyCache sets the cache consulted for password based keys when files are opened or created (OpenExt, CreateExt,
+// Rekey, streams and their variants), nil (the default) disables it. Keys are cached by password, salt and key
+// derivation parameters: files with their own salt hit the cache only when reopened, files sharing a password salt
+// (see WithPasswordSalt) derive it once for all of them.
+func SetKeyCache(deriver KeyDeriver) {
+	keyCache.Lock()
+	defer keyCache.Unlock()
+	keyCache.deriver = deriver
+}
+
+func deriveKey(ctx context.Context, kdf crypto.KDFParameters, password []byte, salt []byte, keyLen int) ([]byte, error) {
+	keyCache.RLock()
+	deriver := keyCache.deriver
+	keyCache.RUnlock()
+	if _, rawKey := kdf.(crypto.HKDFParameters); deriver == nil || rawKey {
+		return crypto.DeriveKeyContext(ctx, kdf, password, salt, keyLen)
+	}
+	return deriver.DeriveKey(ctx, kdf, password, salt, keyLen)
+}
+
+// derivePasswordKey derives the password key (cached, shared by the files with the password salt) and from it the file
+// key, with HKDF-SHA256 and the file salt
+func derivePasswordKey(ctx context.Context, kdf crypto.KDFParameters, password []byte, passwordSalt []byte, salt []byte, keyLen int) ([]byte, error) {
+	passwordKey, err := deriveKey(ctx, kdf, password, passwordSalt, passwordKeyLength)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(passwordKey)
+	return hkdf.Key(sha256.New, passwordKey, salt, passwordKeyInfo, keyLen)
+}
+
+// NewPasswordSalt returns a random password salt, see WithPasswordSalt
+func NewPasswordSalt() []byte {
+	return crypto.RandBytes(passwordSaltLength)
+}
+
+// PasswordSaltFor returns the password salt of the key cache (see SetKeyCache) when it is a PasswordSalter, so new
+// files share it with the ones created before with the same password, or a new random one
+func PasswordSaltFor(ctx context.Context, kdf crypto.KDFParameters, password []byte) ([]byte, error) {
+	keyCache.RLock()
+	salter, ok := keyCache.deriver.(PasswordSalter)
+	keyCache.RUnlock()
+	if !ok {
+		return NewPasswordSalt(), nil
+	}
+	return salter.PasswordSalt(ctx, kdf, password)
+}
+
+// KeyCache keeps derived keys in memory for a time to live, wiping them when expired or purged. Entries are identified
+// by an HMAC (with a random cache key) of the key derivation parameters, salt and password: a wrong password misses,
+// and so does any file with its own salt other than the one the key was derived for. A key missing is derived once,
+// callers asking for it meanwhile wait for it.
+type KeyCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	secret  []byte
+	entries map[[sha256.Size]byte]*cachedKey
+	pending map[[sha256.Size]byte]chan struct{} // keys being derived, closed when done
+	salts   map[[sha256.Size]byte]*cachedKey    // password salts, see PasswordSalt
+}
+
+type cachedKey struct {
+	key   []byte
+	timer *time.Timer
+}
+
+// NewKeyCache returns a cache keeping keys for ttl since derived
+func NewKeyCache(ttl time.Duration) *KeyCache {
+	return &KeyCache{
+		ttl:     ttl,
+		secret:  crypto.RandBytes(sha256.Size),
+		entries: map[[sha256.Size]byte]*cachedKey{},
+		pending: map[[sha256.Size]byte]chan struct{}{},
+		salts:   map[[sha256.Size]byte]*cachedKey{},
+	}
+}
+
+// DeriveKey returns a copy of the cached key, deriving and caching it when not cached
+func (c *KeyCache) DeriveKey(ctx context.Context, kdf crypto.KDFParameters, password []byte, salt []byte, keyLen int) ([]byte, error) {
+	id := c.entryID(kdf, password, salt, keyLen)
+	c.mutex.Lock()
+	for {
+		if entry, ok := c.entries[id]; ok {
+			key := append([]byte{}, entry.key...)
+			c.mutex.Unlock()
+			return key, nil
+		}
+		derived, ok := c.pending[id]
+		if !ok {
+			break
+		}
+		c.mutex.Unlock()
+		select {
+		case <-derived: // cached, or failed: derived again
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.mutex.Lock()
+	}
+	derived := make(chan struct{})
+	c.pending[id] = derived
+	c.mutex.Unlock()
+
+	key, err := crypto.DeriveKeyContext(ctx, kdf, password, salt, keyLen)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.pending, id)
+	close(derived)
+	if err != nil {
+		return nil, err
+	}
+	entry := &cachedKey{key: append([]byte{}, key...)}
+	entry.timer = time.AfterFunc(c.ttl, func() { c.expire(c.entries, id, entry) })
+	c.entries[id] = entry
+	return key, nil
+}
+
+// PasswordSalt returns the password salt for new files with the password and key derivation parameters: a random one,
+// kept for the time to live, files created meanwhile share it (see WithPasswordSalt)
+func (c *KeyCache) PasswordSalt(_ context.Context, kdf crypto.KDFParameters, password []byte) ([]byte, error) {
+	id := c.entryID(kdf, password, nil, 0)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.salts[id]
+	if !ok {
+		entry = &cachedKey{key: NewPasswordSalt()}
+		entry.timer = time.AfterFunc(c.ttl, func() { c.expire(c.salts, id, entry) })
+		c.salts[id] = entry
+	}
+	return append([]byte{}, entry.key...), nil
+}
+
+// Len returns how many keys are cached
+func (c *KeyCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
+
+// Purge wipes and removes all cached keys, and forgets the password salts
+func (c *KeyCache) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, entries := range []map[[sha256.Size]byte]*cachedKey{c.entries, c.salts} {
+		for id, entry := range entries {
+			entry.timer.Stop()
+			wipe(entry.key)
+			delete(entries, id)
+		}
+	}
+}
+
+func (c *KeyCache) expire(entries map[[sha256.Size]byte]*cachedKey, id [sha256.Size]byte, entry *cachedKey) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entries[id] == entry {
+		wipe(entry.key)
+		delete(entries, id)
+	}
+}
+
+func (c *KeyCache) entryID(kdf crypto.KDFParameters, password []byte, salt []byte, keyLen int) (id [sha256.Size]byte) {
+	mac := hmac.New(sha256.New, c.secret)
+	params := fmt.Sprintf("%T%+v", kdf, kdf)
+	for _, field := range [][]byte{[]byte(params), salt, password} {
+		_ = binary.Write(mac, binary.LittleEndian, uint32(len(field)))
+		mac.Write(field)
+	}
+	_ = binary.Write(mac, binary.LittleEndian, uint32(keyLen))
+	copy(id[:], mac.Sum(nil))
+	return id
+}
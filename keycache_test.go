@@ -0,0 +1,151 @@
+package seof
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kuking/seof/crypto"
+)
+
+type countingDeriver struct {
+	cache   *KeyCache
+	derived int
+}
+
+func (c *countingDeriver) DeriveKey(ctx context.Context, kdf crypto.KDFParameters, password []byte, salt []byte, keyLen int) ([]byte, error) {
+	c.derived++
+	return c.cache.DeriveKey(ctx, kdf, password, salt, keyLen)
+}
+
+func TestKeyCache(t *testing.T) {
+	cache := NewKeyCache(time.Minute)
+	salt := crypto.RandBytes(96)
+	key, err := cache.DeriveKey(context.Background(), crypto.MinSCryptParameters, []byte(password), salt, 96)
+	assertNoErr(err, t)
+	expected, _ := crypto.MinSCryptParameters.DeriveKey([]byte(password), salt, 96)
+	if !bytes.Equal(key, expected) || cache.Len() != 1 {
+		t.Fatal()
+	}
+	wipe(key) // callers wipe their copy
+	cached, err := cache.DeriveKey(context.Background(), crypto.MinSCryptParameters, []byte(password), salt, 96)
+	assertNoErr(err, t)
+	if !bytes.Equal(cached, expected) || cache.Len() != 1 {
+		t.Fatal("should be cached")
+	}
+
+	// a different password, salt or parameters is another key
+	other, _ := cache.DeriveKey(context.Background(), crypto.MinSCryptParameters, []byte("wrong password"), salt, 96)
+	_, _ = cache.DeriveKey(context.Background(), crypto.MinSCryptParameters, []byte(password), crypto.RandBytes(96), 96)
+	_, _ = cache.DeriveKey(context.Background(), crypto.MinArgon2idParameters, []byte(password), salt, 96)
+	if bytes.Equal(other, expected) || cache.Len() != 4 {
+		t.Fatal()
+	}
+	cache.Purge()
+	if cache.Len() != 0 {
+		t.Fatal("purged")
+	}
+}
+
+func TestKeyCache_Expires(t *testing.T) {
+	cache := NewKeyCache(50 * time.Millisecond)
+	_, err := cache.DeriveKey(context.Background(), crypto.MinSCryptParameters, []byte(password), crypto.RandBytes(96), 96)
+	assertNoErr(err, t)
+	if cache.Len() != 1 {
+		t.Fatal()
+	}
+	for start := time.Now(); cache.Len() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("should expire")
+		}
+	}
+}
+
+func TestSetKeyCache(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	deriver := &countingDeriver{cache: NewKeyCache(time.Minute)}
+	SetKeyCache(deriver)
+	defer SetKeyCache(nil)
+	data := crypto.RandBytes(BEBlockSize + 10)
+
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 1)
+	assertNoErr(err, t)
+	_, err = f.Write(data)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	for i := 0; i < 3; i++ {
+		f, err = OpenExt(tempFile.Name(), []byte(password), 1)
+		assertNoErr(err, t)
+		read, err := io.ReadAll(f)
+		assertNoErr(err, t)
+		if !bytes.Equal(data, read) {
+			t.Fatal()
+		}
+		assertNoErr(f.Close(), t)
+	}
+	if _, err = OpenExt(tempFile.Name(), []byte("wrong password"), 1); err != ErrWrongPassword {
+		t.Fatal(err)
+	}
+	if deriver.derived != 5 || deriver.cache.Len() != 2 {
+		t.Fatal("derived", deriver.derived, "cached", deriver.cache.Len())
+	}
+}
+
+func TestPasswordSalt(t *testing.T) {
+	dir := t.TempDir()
+	deriver := &countingDeriver{cache: NewKeyCache(time.Minute)}
+	SetKeyCache(deriver)
+	defer SetKeyCache(nil)
+	salt, err := PasswordSaltFor(context.Background(), crypto.MinSCryptParameters, []byte(password))
+	assertNoErr(err, t)
+
+	names := []string{filepath.Join(dir, "1.seof"), filepath.Join(dir, "2.seof")}
+	for _, name := range names {
+		f, err := Create(name, WithPassword([]byte(password)), WithKDF(crypto.MinSCryptParameters), WithPasswordSalt(salt))
+		assertNoErr(err, t)
+		_, err = f.Write([]byte(name))
+		assertNoErr(err, t)
+		assertNoErr(f.Close(), t)
+	}
+	for _, name := range names {
+		f, err := Open(name, WithPassword([]byte(password)))
+		assertNoErr(err, t)
+		read, err := io.ReadAll(f)
+		assertNoErr(err, t)
+		if string(read) != name {
+			t.Fatal()
+		}
+		assertNoErr(f.Close(), t)
+	}
+	if deriver.derived != 4 || deriver.cache.Len() != 1 {
+		t.Fatal("the password key should be derived once, derived", deriver.derived, "cached", deriver.cache.Len())
+	}
+	if _, err = Open(names[0], WithPassword([]byte("wrong password"))); err != ErrWrongPassword {
+		t.Fatal(err)
+	}
+
+	// without a cache, still readable
+	SetKeyCache(nil)
+	f, err := Open(names[1], WithPassword([]byte(password)))
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+}
+
+func TestKeyCache_PasswordSalt(t *testing.T) {
+	cache := NewKeyCache(time.Minute)
+	salt, _ := cache.PasswordSalt(context.Background(), crypto.MinSCryptParameters, []byte(password))
+	same, _ := cache.PasswordSalt(context.Background(), crypto.MinSCryptParameters, []byte(password))
+	other, _ := cache.PasswordSalt(context.Background(), crypto.MinSCryptParameters, []byte("other password"))
+	if len(salt) != passwordSaltLength || !bytes.Equal(salt, same) || bytes.Equal(salt, other) {
+		t.Fatal()
+	}
+	cache.Purge()
+	if again, _ := cache.PasswordSalt(context.Background(), crypto.MinSCryptParameters, []byte(password)); bytes.Equal(salt, again) {
+		t.Fatal("purged")
+	}
+}
@@ -0,0 +1,29 @@
+package seof
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Key check value: an HMAC-SHA256 of the header and its extension (with a zero key check), stored in the header
+// extension. The HMAC key is derived from the file key with HKDF-SHA256 and a label, the ciphers keys are not used
+// by another primitive. A wrong password is detected before decrypting any block, and the file is committed to one
+// key: the GCM layers alone are not key-committing, a ciphertext could be crafted to authenticate under several
+// passwords (partitioning oracle attacks). It also authenticates the header and its extension.
+
+const keyCheckLength = sha256.Size
+
+const keyCheckLabel = "seof key check"
+
+func (f *File) keyCheck(key []byte, header *Header) []byte {
+	checkKey, _ := hkdf.Key(sha256.New, key, nil, keyCheckLabel, sha256.Size) // fails only for too long keys
+	defer wipe(checkKey)
+	mac := hmac.New(sha256.New, checkKey)
+	_ = binary.Write(mac, binary.LittleEndian, header)
+	ext := f.ext
+	ext.KeyCheck = [keyCheckLength]byte{}
+	mac.Write(ext.Bytes())
+	return mac.Sum(nil)
+}
@@ -0,0 +1,111 @@
+package seof
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestKeyCheck_WrongPasswordOrCorruptBlockZero(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	f := givenFile(t, openWithPassword, tempFile.Name(), crypto.RandBytes(BEBlockSize*2+5), WithKeyCheck())
+	assertNoErr(f.Close(), t)
+	if f.ext.KeyCheck == ([keyCheckLength]byte{}) {
+		t.Fatal("the file should have a key check value")
+	}
+
+	flipByte(t, tempFile.Name(), f.dataOffset+50)
+
+	if _, err := OpenExt(tempFile.Name(), []byte("wrong password"), 1); err != ErrWrongPassword {
+		t.Fatal(err)
+	}
+	var corrupt *ErrCorruptBlock
+	if _, err := OpenExt(tempFile.Name(), []byte(password), 1); !errors.As(err, &corrupt) || corrupt.BlockNo != 0 {
+		t.Fatal("block zero is corrupt, the password is right:", err)
+	}
+}
+
+func TestKeyCheck_TamperedHeader(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	f := givenFile(t, openWithPassword, tempFile.Name(), crypto.RandBytes(BEBlockSize*2+5), WithKeyCheck(),
+		WithParity(testParity))
+	assertNoErr(f.Close(), t)
+
+	// parity record: 4 data blocks -> 5
+	content, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+	content[HeaderLength+4+4] = 5
+	assertNoErr(os.WriteFile(tempFile.Name(), content, 0600), t)
+	if _, err = OpenExt(tempFile.Name(), []byte(password), 1); err != ErrWrongPassword {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyCheck_Stripped(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	f := givenFile(t, openWithPassword, tempFile.Name(), crypto.RandBytes(BEBlockSize*2+5), WithKeyCheck())
+	assertNoErr(f.Close(), t)
+	content, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+
+	// the extension record removed, and the whole extension removed (as a file of previous versions)
+	emptyExt := (&HeaderExt{}).Bytes()
+	withEmptyExt := append(append(append([]byte{}, content[:HeaderLength]...), emptyExt...), content[f.dataOffset:]...)
+	withoutExt := append(append([]byte{}, content[:HeaderLength]...), content[f.dataOffset:]...)
+	binary.LittleEndian.PutUint64(withoutExt, HeaderMagic)
+	for _, stripped := range [][]byte{withEmptyExt, withoutExt} {
+		assertNoErr(os.WriteFile(tempFile.Name(), stripped, 0600), t)
+		if _, err = OpenExt(tempFile.Name(), []byte(password), 1); err == nil {
+			t.Fatal("a file without its key check value should not open")
+		}
+	}
+}
+
+func TestKeyCheck_OptIn(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	data := crypto.RandBytes(BEBlockSize*2 + 5)
+
+	// without it, files have no header extension: previous versions open them
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 1)
+	assertNoErr(err, t)
+	_, err = f.Write(data)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	if f.header.Magic != HeaderMagic || f.dataOffset != int64(HeaderLength) {
+		t.Fatal("files without a key check value should keep the previous format")
+	}
+
+	f, err = OpenExt(tempFile.Name(), []byte(password), 1)
+	assertNoErr(err, t)
+	read, err := io.ReadAll(f)
+	assertNoErr(err, t)
+	if string(read) != string(data) {
+		t.Fatal()
+	}
+	assertNoErr(f.Close(), t)
+	if _, err = OpenExt(tempFile.Name(), []byte("wrong password"), 1); err != ErrWrongPassword {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyCheck_Rekey(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	f := givenFile(t, openWithPassword, tempFile.Name(), crypto.RandBytes(BEBlockSize*2+5), WithKeyCheck())
+	assertNoErr(f.Close(), t)
+	assertNoErr(Rekey(tempFile.Name(), []byte(password), []byte("new password"), crypto.MinSCryptParameters, 1), t)
+	f, err := OpenExt(tempFile.Name(), []byte("new password"), 1)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	if f.ext.KeyCheck == ([keyCheckLength]byte{}) {
+		t.Fatal("rekeyed files should keep their key check value")
+	}
+}
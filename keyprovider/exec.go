@@ -0,0 +1,47 @@
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Exec delegates wrapping to an external command (a plugin): it is run with its arguments followed by "wrap" or
+// "unwrap", reading the base64 key from stdin and writing the base64 result to stdout. i.e. with Vault transit:
+//
+//	#!/bin/sh
+//	case "$1" in
+//	  wrap)   vault write -field=ciphertext transit/encrypt/seof plaintext=- | base64 ;;
+//	  unwrap) base64 -d | vault write -field=plaintext transit/decrypt/seof ciphertext=- ;;
+//	esac
+type Exec struct {
+	Command string
+	Args    []string
+}
+
+func (e *Exec) WrapKey(ctx context.Context, dek []byte) ([]byte, error) {
+	return e.run(ctx, "wrap", dek)
+}
+
+func (e *Exec) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return e.run(ctx, "unwrap", wrapped)
+}
+
+func (e *Exec) run(ctx context.Context, operation string, input []byte) ([]byte, error) {
+	args := append(append([]string{}, e.Args...), operation)
+	cmd := exec.CommandContext(ctx, e.Command, args...)
+	cmd.Stdin = bytes.NewReader([]byte(base64.StdEncoding.EncodeToString(input) + "\n"))
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("exec: %v %v failed: %w", e.Command, operation, err)
+	}
+	result, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(output)))
+	if err != nil {
+		return nil, fmt.Errorf("exec: %v %v output is not base64: %w", e.Command, operation, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,30 @@
+package keyprovider
+
+import (
+	"context"
+	"testing"
+)
+
+// a reversible toy wrapping: the base64 input reversed, checking the operation argument
+const toyPlugin = `read input
+case "$1" in
+  wrap)   printf '%s' "$input" | rev | sed 's/^/W/' | base64 ;;
+  unwrap) printf '%s' "$input" | base64 -d | sed 's/^W//' | rev ;;
+  *)      exit 1 ;;
+esac`
+
+func TestExec(t *testing.T) {
+	assertWrapping(t, &Exec{Command: "sh", Args: []string{"-c", toyPlugin, "plugin"}})
+}
+
+func TestExec_Failing(t *testing.T) {
+	for _, e := range []*Exec{
+		{Command: "sh", Args: []string{"-c", "exit 3", "plugin"}},
+		{Command: "sh", Args: []string{"-c", "echo 'not base64!'", "plugin"}},
+		{Command: "/does/not/exist"},
+	} {
+		if _, err := e.WrapKey(context.Background(), []byte("key")); err == nil {
+			t.Fatal("should fail:", e.Args)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// HTTP wraps keys with an envelope encryption service, binary values are base64 in JSON (as encoding/json does):
+//
+//	POST <URL>/wrap   {"plaintext": "<base64>"}  -> {"ciphertext": "<base64>"}
+//	POST <URL>/unwrap {"ciphertext": "<base64>"} -> {"plaintext": "<base64>"}
+//
+// Header is added to every request (i.e. an Authorization token).
+type HTTP struct {
+	URL    string
+	Header http.Header
+	Client *http.Client // http.DefaultClient when nil
+}
+
+type envelope struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+const maxResponseLength = 64 * 1024
+
+func (h *HTTP) WrapKey(ctx context.Context, dek []byte) ([]byte, error) {
+	response, err := h.post(ctx, "wrap", envelope{Plaintext: dek})
+	if err != nil {
+		return nil, err
+	}
+	return response.Ciphertext, nil
+}
+
+func (h *HTTP) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	response, err := h.post(ctx, "unwrap", envelope{Ciphertext: wrapped})
+	if err != nil {
+		return nil, err
+	}
+	return response.Plaintext, nil
+}
+
+func (h *HTTP) post(ctx context.Context, operation string, request envelope) (*envelope, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(h.URL, "/")+"/"+operation, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range h.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http: %v failed: %v", operation, resp.Status)
+	}
+	response := envelope{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseLength)).Decode(&response); err != nil {
+		return nil, fmt.Errorf("http: %v invalid response: %w", operation, err)
+	}
+	if (operation == "wrap" && len(response.Ciphertext) == 0) || (operation == "unwrap" && len(response.Plaintext) == 0) {
+		return nil, fmt.Errorf("http: %v empty response", operation)
+	}
+	return &response, nil
+}
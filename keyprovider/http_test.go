@@ -0,0 +1,81 @@
+package keyprovider
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+// givenEnvelopeService stands in for a KMS, wrapping with AES-GCM and requiring a bearer token
+func givenEnvelopeService(t *testing.T) *httptest.Server {
+	block, _ := aes.NewCipher(crypto.RandBytes(32))
+	aead, _ := cipher.NewGCM(block)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		request := envelope{}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&request) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response := envelope{}
+		switch r.URL.Path {
+		case "/kms/wrap":
+			nonce := crypto.RandBytes(aead.NonceSize())
+			response.Ciphertext = aead.Seal(nonce, nonce, request.Plaintext, nil)
+		case "/kms/unwrap":
+			if len(request.Ciphertext) < aead.NonceSize() {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			plaintext, err := aead.Open(nil, request.Ciphertext[:aead.NonceSize()], request.Ciphertext[aead.NonceSize():], nil)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			response.Plaintext = plaintext
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTP(t *testing.T) {
+	server := givenEnvelopeService(t)
+	assertWrapping(t, &HTTP{
+		URL:    server.URL + "/kms/",
+		Header: http.Header{"Authorization": {"Bearer token"}},
+		Client: server.Client(),
+	})
+}
+
+func TestHTTP_Failing(t *testing.T) {
+	server := givenEnvelopeService(t)
+	for _, h := range []*HTTP{
+		{URL: server.URL + "/kms"},
+		{URL: server.URL + "/other", Header: http.Header{"Authorization": {"Bearer token"}}},
+		{URL: "http://127.0.0.1:1/kms"},
+	} {
+		if _, err := h.WrapKey(context.Background(), []byte("key")); err == nil {
+			t.Fatal("should fail:", h.URL)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h := &HTTP{URL: server.URL + "/kms", Header: http.Header{"Authorization": {"Bearer token"}}}
+	if _, err := h.WrapKey(ctx, []byte("key")); err == nil {
+		t.Fatal("cancelled context should fail")
+	}
+}
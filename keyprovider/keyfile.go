@@ -0,0 +1,71 @@
+// Package keyprovider has seof.KeyProvider implementations: a local key file, an external command and an HTTP envelope
+// encryption service. Wrapped keys are opaque bytes stored in the seof header.
+package keyprovider
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/kuking/seof/crypto"
+)
+
+const keyFileAD = "seof keyfile"
+
+// KeyFile wraps keys with AES-256-GCM using a key kept in a local file (64 hexadecimal characters)
+type KeyFile struct {
+	aead cipher.AEAD
+}
+
+// NewKeyFile reads a key file, see GenerateKeyFile
+func NewKeyFile(path string) (*KeyFile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("keyfile: expected 64 hexadecimal characters")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyFile{aead: aead}, nil
+}
+
+// GenerateKeyFile writes a new random key file, readable only by its owner. Existing files are not overwritten.
+func GenerateKeyFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(hex.EncodeToString(crypto.RandBytes(32)) + "\n")
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// WrapKey returns nonce || AES-256-GCM(dek)
+func (k *KeyFile) WrapKey(_ context.Context, dek []byte) ([]byte, error) {
+	nonce := crypto.RandBytes(k.aead.NonceSize())
+	return k.aead.Seal(nonce, nonce, dek, []byte(keyFileAD)), nil
+}
+
+func (k *KeyFile) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < k.aead.NonceSize() {
+		return nil, errors.New("keyfile: wrapped key too short")
+	}
+	nonceSize := k.aead.NonceSize()
+	return k.aead.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], []byte(keyFileAD))
+}
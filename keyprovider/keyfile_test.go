@@ -0,0 +1,67 @@
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seof.key")
+	if err := GenerateKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if GenerateKeyFile(path) == nil {
+		t.Fatal("existing key files should not be overwritten")
+	}
+	kf, err := NewKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWrapping(t, kf)
+
+	other := filepath.Join(t.TempDir(), "other.key")
+	_ = GenerateKeyFile(other)
+	okf, _ := NewKeyFile(other)
+	wrapped, _ := kf.WrapKey(context.Background(), crypto.RandBytes(32))
+	if _, err = okf.UnwrapKey(context.Background(), wrapped); err == nil {
+		t.Fatal("another key should not unwrap")
+	}
+}
+
+func TestKeyFile_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seof.key")
+	for _, content := range []string{"", "not hex", "00112233"} {
+		_ = os.WriteFile(path, []byte(content), 0600)
+		if _, err := NewKeyFile(path); err == nil {
+			t.Fatal("invalid key file should fail:", content)
+		}
+	}
+}
+
+func assertWrapping(t *testing.T, provider interface {
+	WrapKey(ctx context.Context, dek []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}) {
+	ctx := context.Background()
+	dek := crypto.RandBytes(32)
+	wrapped, err := provider.WrapKey(ctx, dek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := provider.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dek, unwrapped) {
+		t.Fatal("unwrapped key differs")
+	}
+	wrapped[len(wrapped)-1] ^= 1
+	if unwrapped, err = provider.UnwrapKey(ctx, wrapped); err == nil && bytes.Equal(dek, unwrapped) {
+		t.Fatal("tampered wrapped key should not unwrap")
+	}
+}
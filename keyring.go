@@ -0,0 +1,141 @@
+package seof
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/kuking/seof/crypto"
+)
+
+// Keyring holds master keys identified by key IDs. Files created with a keyring carry the active key ID in their header,
+// in the clear but authenticated (it is part of the key derivation), and are opened by looking up their key. Each file
+// ciphers keys are derived from the master key and the file salt with HKDF. Keys are rotated by adding a new key and
+// making it active, files created with previous keys can still be opened while their keys are in the keyring.
+type Keyring struct {
+	mutex  sync.RWMutex
+	keys   map[string][]byte
+	active string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string][]byte{}}
+}
+
+// Add adds a master key (at least crypto.MinRawKeyLength bytes), the first key added becomes the active one
+func (k *Keyring) Add(keyID string, key []byte) error {
+	if len(keyID) < 1 || len(keyID) > maxKeyIDLength {
+		return errors.New("key id length should be between 1 and 255 bytes")
+	}
+	if len(key) < crypto.MinRawKeyLength {
+		return errors.New("raw key should be at least 32 bytes long")
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if _, ok := k.keys[keyID]; ok {
+		return fmt.Errorf("key id %v already in the keyring", keyID)
+	}
+	k.keys[keyID] = append([]byte{}, key...)
+	if k.active == "" {
+		k.active = keyID
+	}
+	return nil
+}
+
+// Remove removes a key, files created with it can not be opened with this keyring anymore. The active key can not be
+// removed.
+func (k *Keyring) Remove(keyID string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	key, ok := k.keys[keyID]
+	if !ok {
+		return fmt.Errorf("key id %v not in the keyring", keyID)
+	}
+	if keyID == k.active {
+		return errors.New("the active key can not be removed")
+	}
+	for i := range key {
+		key[i] = 0
+	}
+	delete(k.keys, keyID)
+	return nil
+}
+
+// SetActive sets the key used for creating files
+func (k *Keyring) SetActive(keyID string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if _, ok := k.keys[keyID]; !ok {
+		return fmt.Errorf("key id %v not in the keyring", keyID)
+	}
+	k.active = keyID
+	return nil
+}
+
+func (k *Keyring) Active() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.active
+}
+
+// KeyIDs returns the key ids in the keyring, sorted
+func (k *Keyring) KeyIDs() []string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Create creates a file with the active key, see CreateWithKey
+func (k *Keyring) Create(name string, parity ParityParameters, BEBlockSize int, memoryBuffers int) (*File, error) {
+	return k.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666, WithParity(parity), WithBlockSize(BEBlockSize),
+		WithCacheSize(memoryBuffers))
+}
+
+// Open opens a file created with a key in the keyring for reading
+func (k *Keyring) Open(name string, memoryBuffers int) (*File, error) {
+	return k.OpenFile(name, os.O_RDONLY, 0, WithCacheSize(memoryBuffers))
+}
+
+// OpenFile opens or creates a file like the package OpenFile, files are created with the active key and opened with
+// the key of their key id. The options can not give a password, key, key provider or key derivation.
+func (k *Keyring) OpenFile(name string, flag int, perm os.FileMode, opts ...Option) (*File, error) {
+	k.mutex.RLock()
+	keyID := k.active
+	key := append([]byte{}, k.keys[keyID]...) // a copy, Remove wipes keys
+	k.mutex.RUnlock()
+	defer wipe(key)
+	if keyID == "" {
+		return nil, errors.New("keyring is empty")
+	}
+	opts = append(opts, func(o *Options) error {
+		if o.Password != nil || o.Key != nil || o.KeyProvider != nil || o.KDF != nil {
+			return errors.New("keyring files use the keyring keys")
+		}
+		o.Key = key
+		o.KDF = crypto.HKDFParameters{KeyID: keyID}
+		o.keyring = k
+		return nil
+	})
+	return OpenFile(name, flag, perm, opts...)
+}
+
+// keyFor returns a copy of the key of a file's key id
+func (k *Keyring) keyFor(keyID string) ([]byte, error) {
+	if keyID == "" {
+		return nil, errors.New("file was not created with a keyring")
+	}
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key id %v not in the keyring", keyID)
+	}
+	return append([]byte{}, master...), nil
+}
@@ -0,0 +1,128 @@
+package seof
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(oldFile)
+	newFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(newFile)
+
+	keyring := NewKeyring()
+	if _, err := keyring.Create(oldFile.Name(), NoParity, BEBlockSize, 1); err == nil {
+		t.Fatal("empty keyring should not create files")
+	}
+	key2021 := crypto.RandBytes(32)
+	assertNoErr(keyring.Add("team-2021", key2021), t)
+	assertNoErr(givenFile(t, keyring.OpenFile, oldFile.Name(), []byte("old secrets")).Close(), t)
+
+	assertNoErr(keyring.Add("team-2022", crypto.RandBytes(32)), t)
+	assertNoErr(keyring.SetActive("team-2022"), t)
+	assertNoErr(givenFile(t, keyring.OpenFile, newFile.Name(), []byte("new secrets")).Close(), t)
+
+	assertKeyringFile(t, keyring, oldFile.Name(), "team-2021", []byte("old secrets"))
+	assertKeyringFile(t, keyring, newFile.Name(), "team-2022", []byte("new secrets"))
+
+	// the master key opens it too
+	f, err := OpenWithKey(oldFile.Name(), key2021, 1)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	if keyring.Remove("team-2022") == nil {
+		t.Fatal("active key should not be removed")
+	}
+	assertNoErr(keyring.Remove("team-2021"), t)
+	if _, err = keyring.Open(oldFile.Name(), 1); err == nil {
+		t.Fatal("removed keys should not open files")
+	}
+	if ids := keyring.KeyIDs(); len(ids) != 1 || ids[0] != "team-2022" {
+		t.Fatal(ids)
+	}
+}
+
+func TestKeyring_TamperedKeyID(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	key := crypto.RandBytes(32)
+	keyring := NewKeyring()
+	assertNoErr(keyring.Add("key-a", key), t)
+	assertNoErr(keyring.Add("key-b", key), t)
+	assertNoErr(givenFile(t, keyring.OpenFile, tempFile.Name(), []byte("secrets")).Close(), t)
+
+	// key-a -> key-b in the header extension, same key: the key id is authenticated by the key derivation
+	raw, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+	idx := bytes.Index(raw, []byte("key-a"))
+	if idx < 0 {
+		t.Fatal("key id should be in the clear")
+	}
+	raw[idx+4] = 'b'
+	assertNoErr(os.WriteFile(tempFile.Name(), raw, 0600), t)
+	if _, err = keyring.Open(tempFile.Name(), 1); err == nil {
+		t.Fatal("tampered key id should not open")
+	}
+}
+
+func TestKeyring_InvalidArguments(t *testing.T) {
+	keyring := NewKeyring()
+	if keyring.Add("", crypto.RandBytes(32)) == nil || keyring.Add(string(make([]byte, 256)), crypto.RandBytes(32)) == nil {
+		t.Fatal("key id length should be checked")
+	}
+	if keyring.Add("short", crypto.RandBytes(31)) == nil {
+		t.Fatal("short keys should not be accepted")
+	}
+	assertNoErr(keyring.Add("a", crypto.RandBytes(32)), t)
+	if keyring.Add("a", crypto.RandBytes(32)) == nil {
+		t.Fatal("duplicated key ids should not be accepted")
+	}
+	if keyring.SetActive("unknown") == nil || keyring.Active() != "a" {
+		t.Fatal()
+	}
+}
+
+func assertKeyringFile(t *testing.T, keyring *Keyring, name string, keyID string, data []byte) {
+	f, err := keyring.Open(name, 1)
+	assertNoErr(err, t)
+	read, err := io.ReadAll(f)
+	assertNoErr(err, t)
+	if !bytes.Equal(data, read) {
+		t.Fatal()
+	}
+	stats, err := f.Stat()
+	assertNoErr(err, t)
+	if stats.KDF() != (crypto.HKDFParameters{KeyID: keyID}) {
+		t.Fatal("expected key id", keyID, "got", stats.KDF())
+	}
+	assertNoErr(f.Close(), t)
+}
+
+func TestKeyring_OpenFileForWriting(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	keyring := NewKeyring()
+	assertNoErr(keyring.Add("key-a", crypto.RandBytes(32)), t)
+	assertNoErr(givenFile(t, keyring.OpenFile, tempFile.Name(), []byte("old secrets")).Close(), t)
+	assertNoErr(keyring.Add("key-b", crypto.RandBytes(32)), t)
+	assertNoErr(keyring.SetActive("key-b"), t)
+
+	// modified with the key it was created with, not the active one
+	f, err := keyring.OpenFile(tempFile.Name(), os.O_RDWR, 0, WithCacheSize(1))
+	assertNoErr(err, t)
+	_, err = f.WriteAt([]byte("new"), 0)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	assertKeyringFile(t, keyring, tempFile.Name(), "key-a", []byte("new secrets"))
+
+	if _, err = keyring.OpenFile(tempFile.Name(), os.O_RDWR, 0, WithPassword([]byte(password))); err == nil {
+		t.Fatal("keyring files should not take other secrets")
+	}
+}
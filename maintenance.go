@@ -0,0 +1,148 @@
+package seof
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kuking/seof/crypto"
+)
+
+// Verify reads and authenticates every block in the file, checking their sizes agree with the file size. Blocks never
+// written (i.e. holes in sparse files) fail verification.
+func (f *File) Verify() error {
+	return f.VerifyContext(context.Background())
+}
+
+// VerifyContext verifies the file like Verify, returning ctx.Err() when ctx is done before all blocks are checked
+func (f *File) VerifyContext(ctx context.Context) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.resealCache()
+	if f.pendingErr != nil {
+		return *f.pendingErr
+	}
+	lastBlockNo := f.lastBlockNo()
+	for blockNo := int64(1); blockNo <= lastBlockNo; blockNo++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		imb, err := f.getOrLoadBlock(blockNo)
+		if err == io.EOF {
+			err = &ErrCorruptBlock{BlockNo: blockNo, Offset: f.blockOffset(blockNo), Err: ErrTruncated}
+		}
+		if err != nil {
+			return err
+		}
+		expected := int(f.blockZero.BEncBlockSize)
+		longer := false
+		if blockNo == lastBlockNo {
+			// it can hold appends written through but not in block zero yet, lost on a crash before Sync
+			expected = int(f.blockZero.BEncFileSize - uint64(lastBlockNo-1)*uint64(f.blockZero.BEncBlockSize))
+			longer = len(imb.plainText) > expected
+		}
+		if len(imb.plainText) != expected && !longer {
+			return &ErrCorruptBlock{BlockNo: blockNo, Offset: f.blockOffset(blockNo),
+				Err: fmt.Errorf("expected %v bytes, found %v", expected, len(imb.plainText))}
+		}
+	}
+	return nil
+}
+
+// Rekey re-encrypts a file with a new password (salt and key derivation parameters), the original file is replaced
+// when completed (the new file and the rename are synced to disk first). Block size, parity and attributes are kept.
+func Rekey(name string, password []byte, newPassword []byte, kdf crypto.KDFParameters, memoryBuffers int) error {
+	return RekeyContext(context.Background(), name, password, newPassword, kdf, memoryBuffers)
+}
+
+// RekeyContext re-encrypts a file like Rekey, when ctx is done it stops returning ctx.Err() and the original file is
+// left untouched
+func RekeyContext(ctx context.Context, name string, password []byte, newPassword []byte, kdf crypto.KDFParameters, memoryBuffers int) error {
+	src, err := OpenExtContext(ctx, name, password, memoryBuffers)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	stats, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".rekey*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	_ = tmp.Close()
+	err = rekeyInto(ctx, src, stats, tmpName, newPassword, kdf, memoryBuffers)
+	if err == nil {
+		err = os.Chmod(tmpName, stats.Mode())
+	}
+	if err == nil {
+		err = os.Rename(tmpName, name)
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+func rekeyInto(ctx context.Context, src *File, stats *FileInfo, name string, newPassword []byte, kdf crypto.KDFParameters, memoryBuffers int) error {
+	opts := []Option{WithContext(ctx), WithPassword(newPassword), WithKDF(kdf), WithParity(stats.Parity()),
+		WithBlockSize(int(stats.BEBlockSize())), WithCacheSize(memoryBuffers), WithDurability(DurabilityClose)}
+	if src.ext.KeyCheck != ([keyCheckLength]byte{}) {
+		opts = append(opts, WithKeyCheck())
+	}
+	dst, err := Create(name, opts...)
+	if err != nil {
+		return err
+	}
+	for key, value := range stats.Attrs() {
+		if err = dst.SetAttr(key, value); err != nil {
+			_ = dst.Close()
+			return err
+		}
+	}
+	if _, err = CopyContext(ctx, dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// CopyContext copies src into dst like io.Copy, until EOF or an error. ctx is checked between blocks (of the seof
+// File being read or written, 32KB otherwise), returning ctx.Err() when done.
+func CopyContext(ctx context.Context, dst io.Writer, src io.Reader) (written int64, err error) {
+	size := 32 * 1024
+	for _, rw := range []any{src, dst} {
+		if f, ok := rw.(*File); ok {
+			size = int(f.blockZero.BEncBlockSize)
+		}
+	}
+	buf := make([]byte, size)
+	for {
+		if err = ctx.Err(); err != nil {
+			return written, err
+		}
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			w, writeErr := dst.Write(buf[:n])
+			written += int64(w)
+			if writeErr != nil {
+				return written, writeErr
+			}
+			if w != n {
+				return written, io.ErrShortWrite
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package seof
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestFile_Verify(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 1)
+	assertNoErr(err, t)
+	_, err = f.Write(crypto.RandBytes(BEBlockSize*5 + 10))
+	assertNoErr(err, t)
+	assertNoErr(f.Verify(), t)
+	assertNoErr(f.Close(), t)
+
+	f, err = OpenExt(tempFile.Name(), []byte(password), 1)
+	assertNoErr(err, t)
+	assertNoErr(f.Verify(), t)
+	corruptBlock(t, f, 3)
+	f.cache.Purge()
+	if f.Verify() == nil {
+		t.Fatal("a corrupted block should fail verification")
+	}
+	_ = f.Close()
+}
+
+func TestRekey(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	newPassword := []byte("a new password, also long enough")
+
+	data := crypto.RandBytes(BEBlockSize*7 + 123)
+	f, err := CreateExtParity(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, testParity, BEBlockSize, 1)
+	assertNoErr(err, t)
+	assertNoErr(f.SetAttr("key", "value"), t)
+	_, err = f.Write(data)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	assertNoErr(Rekey(tempFile.Name(), []byte(password), newPassword, crypto.MinSCryptParameters, 10), t)
+
+	if _, err = OpenExt(tempFile.Name(), []byte(password), 1); err == nil {
+		t.Fatal("old password should not work")
+	}
+	f, err = OpenExt(tempFile.Name(), newPassword, 1)
+	assertNoErr(err, t)
+	read, err := io.ReadAll(f)
+	assertNoErr(err, t)
+	if !bytes.Equal(data, read) {
+		t.Fatal()
+	}
+	stats, err := f.Stat()
+	assertNoErr(err, t)
+	if stats.Attrs()["key"] != "value" || stats.Parity() != testParity || stats.BEBlockSize() != BEBlockSize {
+		t.Fatal("rekeyed file should keep attributes, parity and block size")
+	}
+	assertNoErr(f.Close(), t)
+
+	if Rekey(tempFile.Name(), []byte(password), newPassword, crypto.MinSCryptParameters, 10) == nil {
+		t.Fatal("rekey with a wrong password should fail")
+	}
+	assertNoErr(Rekey(tempFile.Name(), newPassword, []byte(password), crypto.MinSCryptParameters, MaxCacheSize), t)
+	if Rekey(tempFile.Name(), []byte(password), newPassword, crypto.MinSCryptParameters, MaxCacheSize+1) == nil {
+		t.Fatal("rekey with an invalid cache size should fail")
+	}
+}
+
+func TestContext_Cancelled(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	data := crypto.RandBytes(BEBlockSize*3 + 10)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := CreateExtContext(cancelled, tempFile.Name(), []byte(password), crypto.MinSCryptParameters, NoParity, BEBlockSize, 1); err != context.Canceled {
+		t.Fatal(err)
+	}
+	f, err := CreateExtContext(context.Background(), tempFile.Name(), []byte(password), crypto.MinSCryptParameters, NoParity, BEBlockSize, 1)
+	assertNoErr(err, t)
+	_, err = f.Write(data)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	f, err = OpenExtContext(context.Background(), tempFile.Name(), []byte(password), 1)
+	assertNoErr(err, t)
+	if _, err = OpenExtContext(cancelled, tempFile.Name(), []byte(password), 1); err != context.Canceled {
+		t.Fatal(err)
+	}
+	if err = RekeyContext(cancelled, tempFile.Name(), []byte(password), []byte("a new password, also long enough"), crypto.MinSCryptParameters, 1); err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	if err = f.VerifyContext(cancelled); err != context.Canceled {
+		t.Fatal(err)
+	}
+	assertNoErr(f.VerifyContext(context.Background()), t)
+	if _, err = CopyContext(cancelled, io.Discard, f); err != context.Canceled {
+		t.Fatal(err)
+	}
+	var copied bytes.Buffer
+	n, err := CopyContext(context.Background(), &copied, f)
+	assertNoErr(err, t)
+	if n != int64(len(data)) || !bytes.Equal(data, copied.Bytes()) {
+		t.Fatal()
+	}
+	assertNoErr(f.Close(), t)
+}
+
+func TestContext_Argon2id(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	f, err := CreateExtContext(ctx, tempFile.Name(), []byte(password), crypto.MinArgon2idParameters, NoParity, BEBlockSize, 1)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	f, err = OpenExtContext(ctx, tempFile.Name(), []byte(password), 1)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	cancel()
+	if _, err = OpenExtContext(ctx, tempFile.Name(), []byte(password), 1); err != context.Canceled {
+		t.Fatal(err)
+	}
+}
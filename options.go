@@ -0,0 +1,419 @@
+package seof
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/kuking/seof/crypto"
+)
+
+// Options configure the files created or opened by Create, Open and OpenFile, set with the With functions. One of a
+// password, a key or a key provider is required. The key derivation function, cipher suite, block size and parity
+// are stored in new files, opened files use their own.
+type Options struct {
+	Context        context.Context // for the key derivation and the key provider, context.Background() when nil
+	Password       []byte
+	Key            []byte // raw key material, see CreateWithKey
+	KeyProvider    KeyProvider
+	KDF            crypto.KDFParameters // crypto.RecommendedSCryptParameters, or crypto.HKDFParameters for keys, when nil
+	CipherSuite    CipherSuite
+	BlockSize      int          // before encryption, DefaultBlockSize when 0
+	CacheSize      int          // blocks kept in memory (memoryBuffers), DefaultCacheSize when 0
+	SharedCache    *SharedCache // replaces the file's own cache of CacheSize blocks
+	Parity         ParityParameters
+	KeyCheck       bool   // new files carry a key check value, see WithKeyCheck
+	PasswordSalt   []byte // new files share a password salt, see WithPasswordSalt
+	Sparse         SparsePolicy
+	Durability     Durability
+	WritePolicy    WritePolicy
+	MaxDirtyAge    time.Duration // write-back: modified blocks are written within it, no limit when 0
+	MaxDirtyBlocks int           // write-back: modified blocks are written when reaching it, no limit when 0
+	Logger         *slog.Logger  // repairs, corrupt blocks and errors writing; nothing is logged when nil
+	Rand           io.Reader     // for salts, nonces and data keys, crypto/rand when nil
+	Hooks          Hooks
+
+	keyring *Keyring // files are opened with the key of their key id, see Keyring.OpenFile
+}
+
+// Option sets an option, failing for invalid values
+type Option func(o *Options) error
+
+const (
+	DefaultBlockSize = 4 * 1024
+	DefaultCacheSize = 16
+	MaxCacheSize     = 1024
+)
+
+// CipherSuite is the encryption of the blocks
+type CipherSuite int
+
+const (
+	// CipherTripleAESGCM seals blocks with three layers of AES-256-GCM, with independent keys and nonces
+	CipherTripleAESGCM CipherSuite = iota
+)
+
+// SparsePolicy is how blocks never written (holes, i.e. writing after seeking past the end) are handled
+type SparsePolicy int
+
+const (
+	// SparseStrict fails reading holes: a hole can not be told apart from a block zeroed by an attacker
+	SparseStrict SparsePolicy = iota
+	// SparseZeros reads holes as zeros, a block zeroed (on disk) by an attacker is read as zeros as well
+	SparseZeros
+	// SparseFill writes the gap left by writing past the end as encrypted zeros, files have no holes
+	SparseFill
+)
+
+// Durability is when written blocks are synced to disk, Sync always does
+type Durability int
+
+const (
+	// DurabilityNone leaves written blocks to the operating system
+	DurabilityNone Durability = iota
+	// DurabilityClose syncs on Close
+	DurabilityClose
+	// DurabilityFlush syncs after the write policy writes blocks (write-through writes, background flushes) and on Close
+	DurabilityFlush
+	// DurabilitySync opens the file with O_SYNC, every block written reaches the disk (and syncs on Close)
+	DurabilitySync
+)
+
+// WritePolicy is when modified blocks are written to disk, besides Sync and Close
+type WritePolicy int
+
+const (
+	// WriteBack writes modified blocks when evicted from the cache, or when older than MaxDirtyAge or over
+	// MaxDirtyBlocks (by a background goroutine) when set
+	WriteBack WritePolicy = iota
+	// WriteThrough seals and writes the modified blocks on every write, and SetAttr or RemoveAttr. Block zero is
+	// written too when the attributes change or the file is truncated; a grown size on Sync and Close, so appends
+	// seal only their blocks (until then, the file on disk reads as before the appends).
+	WriteThrough
+)
+
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) error {
+		o.Context = ctx
+		return nil
+	}
+}
+
+func WithPassword(password []byte) Option {
+	return func(o *Options) error {
+		o.Password = password
+		return nil
+	}
+}
+
+func WithKey(key []byte) Option {
+	return func(o *Options) error {
+		if len(key) < crypto.MinRawKeyLength {
+			return errors.New("raw key should be at least 32 bytes long")
+		}
+		o.Key = key
+		return nil
+	}
+}
+
+func WithKeyProvider(provider KeyProvider) Option {
+	return func(o *Options) error {
+		o.KeyProvider = provider
+		return nil
+	}
+}
+
+func WithKDF(kdf crypto.KDFParameters) Option {
+	return func(o *Options) error {
+		if kdf == nil {
+			return errors.New("unsupported key derivation function")
+		}
+		if err := kdf.Verify(); err != nil {
+			return err
+		}
+		o.KDF = kdf
+		return nil
+	}
+}
+
+func WithCipherSuite(suite CipherSuite) Option {
+	return func(o *Options) error {
+		if suite != CipherTripleAESGCM {
+			return errors.New("unsupported cipher suite")
+		}
+		o.CipherSuite = suite
+		return nil
+	}
+}
+
+func WithBlockSize(BEBlockSize int) Option {
+	return func(o *Options) error {
+		if BEBlockSize < 1024 || BEBlockSize > 128*1024 {
+			return errors.New("before encryption block size has to be between 1KB and 128KB")
+		}
+		o.BlockSize = BEBlockSize
+		return nil
+	}
+}
+
+func WithCacheSize(memoryBuffers int) Option {
+	return func(o *Options) error {
+		if memoryBuffers < 1 || memoryBuffers > MaxCacheSize {
+			return errors.New("memory buffers can be between 1 and 1024")
+		}
+		o.CacheSize = memoryBuffers
+		return nil
+	}
+}
+
+func WithSharedCache(cache *SharedCache) Option {
+	return func(o *Options) error {
+		o.SharedCache = cache
+		return nil
+	}
+}
+
+func WithParity(parity ParityParameters) Option {
+	return func(o *Options) error {
+		if parity.Enabled() {
+			if err := parity.Verify(); err != nil {
+				return err
+			}
+		}
+		o.Parity = parity
+		return nil
+	}
+}
+
+// WithKeyCheck stores a key check value in new files: a wrong password is told apart from a damaged block zero, and the
+// file is committed to its key. Files with it carry a header extension, versions before it can not open them.
+func WithKeyCheck() Option {
+	return func(o *Options) error {
+		o.KeyCheck = true
+		return nil
+	}
+}
+
+// WithPasswordSalt makes new files share the password salt (see NewPasswordSalt and PasswordSaltFor): their keys are
+// derived from one password key, derived once for all of them with a key cache (see SetKeyCache). Password based files
+// only, versions before it can not open them.
+func WithPasswordSalt(salt []byte) Option {
+	return func(o *Options) error {
+		if len(salt) != passwordSaltLength || isZeroed(salt) {
+			return errors.New("password salt should be 32 random bytes")
+		}
+		o.PasswordSalt = salt
+		return nil
+	}
+}
+
+func WithSparse(policy SparsePolicy) Option {
+	return func(o *Options) error {
+		if policy < SparseStrict || policy > SparseFill {
+			return errors.New("invalid sparse policy")
+		}
+		o.Sparse = policy
+		return nil
+	}
+}
+
+func WithDurability(durability Durability) Option {
+	return func(o *Options) error {
+		if durability < DurabilityNone || durability > DurabilitySync {
+			return errors.New("invalid durability")
+		}
+		o.Durability = durability
+		return nil
+	}
+}
+
+func WithWritePolicy(policy WritePolicy) Option {
+	return func(o *Options) error {
+		if policy < WriteBack || policy > WriteThrough {
+			return errors.New("invalid write policy")
+		}
+		o.WritePolicy = policy
+		return nil
+	}
+}
+
+// WithWriteBack sets the WriteBack policy, written by a background goroutine when modified blocks get older than
+// maxDirtyAge or reach maxDirtyBlocks; zero is no limit
+func WithWriteBack(maxDirtyAge time.Duration, maxDirtyBlocks int) Option {
+	return func(o *Options) error {
+		if maxDirtyAge < 0 || maxDirtyBlocks < 0 {
+			return errors.New("dirty block limits can not be negative")
+		}
+		o.WritePolicy, o.MaxDirtyAge, o.MaxDirtyBlocks = WriteBack, maxDirtyAge, maxDirtyBlocks
+		return nil
+	}
+}
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Options) error {
+		o.Logger = logger
+		return nil
+	}
+}
+
+// WithRand sets the source of salts, nonces and data keys. Failing to read it fails creating the file, or writing the
+// block being sealed (returned by the following operations, as other write errors).
+func WithRand(rand io.Reader) Option {
+	return func(o *Options) error {
+		o.Rand = rand
+		return nil
+	}
+}
+
+func WithHooks(hooks Hooks) Option {
+	return func(o *Options) error {
+		o.Hooks = hooks
+		return nil
+	}
+}
+
+// newOptions applies opts over the defaults, checking the credentials and key derivation function agree
+func newOptions(opts []Option) (*Options, error) {
+	o := &Options{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+	if o.BlockSize == 0 {
+		o.BlockSize = DefaultBlockSize
+	}
+	if o.CacheSize == 0 {
+		o.CacheSize = DefaultCacheSize
+	}
+	switch {
+	case o.Password != nil && o.Key == nil && o.KeyProvider == nil:
+		if _, ok := o.KDF.(crypto.HKDFParameters); ok {
+			return nil, errors.New("use CreateWithKey for raw keys")
+		}
+		if o.KDF == nil {
+			o.KDF = crypto.RecommendedSCryptParameters
+		}
+	case o.Key != nil && o.Password == nil && o.KeyProvider == nil,
+		o.KeyProvider != nil && o.Password == nil && o.Key == nil:
+		if o.KDF == nil {
+			o.KDF = crypto.HKDFParameters{}
+		}
+		if o.PasswordSalt != nil {
+			return nil, errors.New("password salts are only for password based files")
+		}
+		if _, ok := o.KDF.(crypto.HKDFParameters); !ok {
+			return nil, errors.New("key based files use crypto.HKDFParameters")
+		}
+	default:
+		return nil, errors.New("one of a password, a key or a key provider is required")
+	}
+	return o, nil
+}
+
+// Create creates a file (truncating it if it exists) with the options, a password, key or key provider is required
+func Create(name string, opts ...Option) (*File, error) {
+	return OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666, opts...)
+}
+
+// Open opens a file for reading with the options, a password, key or key provider is required
+func Open(name string, opts ...Option) (*File, error) {
+	return OpenFile(name, os.O_RDONLY, 0, opts...)
+}
+
+// OpenFile opens or creates a file like os.OpenFile, os.O_CREATE creates it when missing or empty (and always with
+// os.O_TRUNC or os.O_EXCL). Files opened for writing are read as well (blocks are read to be modified); os.O_APPEND is
+// not supported.
+func OpenFile(name string, flag int, perm os.FileMode, opts ...Option) (*File, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_APPEND != 0 {
+		return nil, errors.New("append mode is not supported")
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		flag = flag&^os.O_WRONLY | os.O_RDWR
+	}
+	if o.Durability == DurabilitySync {
+		flag |= os.O_SYNC
+	}
+	if flag&os.O_CREATE != 0 {
+		info, statErr := os.Stat(name)
+		if flag&(os.O_TRUNC|os.O_EXCL) != 0 || statErr != nil || info.Size() == 0 {
+			return create(name, flag&^os.O_WRONLY|os.O_RDWR, perm, o)
+		}
+	}
+	flag &^= os.O_CREATE | os.O_TRUNC | os.O_EXCL
+
+	switch {
+	case o.Password != nil:
+		return open(name, flag, o, false, fixedSecret(o.Password))
+	case o.keyring != nil:
+		var key []byte
+		defer func() { wipe(key) }()
+		return open(name, flag, o, true, func(ext *HeaderExt) ([]byte, error) {
+			var err error
+			key, err = o.keyring.keyFor(ext.KeyID)
+			return key, err
+		})
+	case o.Key != nil:
+		return open(name, flag, o, true, fixedSecret(o.Key))
+	}
+	var dek []byte
+	defer func() { wipe(dek) }()
+	return open(name, flag, o, true, func(ext *HeaderExt) ([]byte, error) {
+		if ext.WrappedKey == "" {
+			return nil, errors.New("file was not created with a key provider")
+		}
+		var err error
+		dek, err = o.KeyProvider.UnwrapKey(o.Context, []byte(ext.WrappedKey))
+		return dek, err
+	})
+}
+
+// applyOptions sets the file options used after opening
+func (f *File) applyOptions(o *Options) {
+	f.log = o.Logger
+	f.rand = o.Rand
+	f.sparse = o.Sparse
+	f.durability = o.Durability
+	f.writePolicy, f.maxDirtyAge, f.maxDirtyBlocks = o.WritePolicy, o.MaxDirtyAge, o.MaxDirtyBlocks
+	if o.Hooks != nil {
+		f.SetHooks(o.Hooks)
+	}
+}
+
+func (f *File) randBytes(size int) ([]byte, error) {
+	return randBytes(f.rand, size)
+}
+
+// randBytes reads from rand, or crypto/rand when nil
+func randBytes(rand io.Reader, size int) ([]byte, error) {
+	if rand == nil {
+		return crypto.RandBytes(size), nil
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(rand, b); err != nil {
+		return nil, fmt.Errorf("could not generate randomness: %w", err)
+	}
+	return b, nil
+}
+
+// logger returns the file logger, discarding when there is none
+func (f *File) logger() *slog.Logger {
+	if f.log == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	if f.file != nil {
+		return f.log.With("file", f.file.Name())
+	}
+	return f.log
+}
@@ -0,0 +1,197 @@
+package seof
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestOptions_Invalid(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.seof")
+	for _, opts := range [][]Option{
+		{},
+		{WithPassword([]byte(password)), WithKey(crypto.RandBytes(32))},
+		{WithPassword([]byte(password)), WithKDF(crypto.HKDFParameters{})},
+		{WithKey(crypto.RandBytes(32)), WithKDF(crypto.MinSCryptParameters)},
+		{WithPassword([]byte(password)), WithKDF(nil)},
+		{WithPassword([]byte(password)), WithBlockSize(1023)},
+		{WithPassword([]byte(password)), WithCacheSize(MaxCacheSize + 1)},
+		{WithPassword([]byte(password)), WithCipherSuite(CipherSuite(1))},
+		{WithPassword([]byte(password)), WithSparse(SparsePolicy(3))},
+		{WithPassword([]byte(password)), WithDurability(Durability(-1))},
+		{WithPassword([]byte(password)), WithParity(ParityParameters{Data: 1})},
+		{WithPassword([]byte(password)), WithPasswordSalt(crypto.RandBytes(16))},
+		{WithPassword([]byte(password)), WithPasswordSalt(make([]byte, 32))},
+		{WithKey(crypto.RandBytes(32)), WithPasswordSalt(NewPasswordSalt())},
+	} {
+		if _, err := Create(name, opts...); err == nil {
+			t.Fatal("options should be checked")
+		}
+	}
+	if _, err := OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600, WithPassword([]byte(password))); err == nil {
+		t.Fatal("append mode is not supported")
+	}
+}
+
+func TestOpenFile_Flags(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.seof")
+	opts := []Option{WithPassword([]byte(password)), WithKDF(crypto.MinSCryptParameters), WithBlockSize(BEBlockSize)}
+
+	if _, err := OpenFile(name, os.O_RDWR, 0, opts...); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("file does not exist:", err)
+	}
+	f, err := OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600, opts...)
+	assertNoErr(err, t)
+	_, err = f.Write([]byte("hello"))
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	if info, _ := os.Stat(name); info.Mode().Perm() != 0600 {
+		t.Fatal(info.Mode())
+	}
+	if _, err = OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600, opts...); !errors.Is(err, os.ErrExist) {
+		t.Fatal("file exists:", err)
+	}
+
+	// O_CREATE opens existing files
+	f, err = OpenFile(name, os.O_RDWR|os.O_CREATE, 0600, opts...)
+	assertNoErr(err, t)
+	_, err = f.WriteAt([]byte("J"), 0)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	f, err = Open(name, opts...)
+	assertNoErr(err, t)
+	if read, _ := io.ReadAll(f); string(read) != "Jello" {
+		t.Fatal(string(read))
+	}
+	if _, err = f.Write([]byte("read only")); err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		t.Fatal("file was opened for reading")
+	}
+	_ = f.Close()
+
+	// O_TRUNC creates it again
+	f, err = OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600, opts...)
+	assertNoErr(err, t)
+	if info, _ := f.Stat(); info.Size() != 0 {
+		t.Fatal(info.Size())
+	}
+	assertNoErr(f.Close(), t)
+}
+
+func TestOptions_Defaults(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.seof")
+	f, err := Create(name, WithKey(crypto.RandBytes(32)))
+	assertNoErr(err, t)
+	info, _ := f.Stat()
+	if info.BEBlockSize() != DefaultBlockSize || f.Stats().CacheSize != DefaultCacheSize {
+		t.Fatal(info.BEBlockSize(), f.Stats().CacheSize)
+	}
+	if _, ok := info.KDF().(crypto.HKDFParameters); !ok {
+		t.Fatal(info.KDF())
+	}
+	assertNoErr(f.Close(), t)
+}
+
+func TestOptions_Sparse(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, policy SparsePolicy) {
+		f, err := Create(filepath.Join(dir, name), WithPassword([]byte(password)), WithKDF(crypto.MinSCryptParameters),
+			WithBlockSize(BEBlockSize), WithSparse(policy))
+		assertNoErr(err, t)
+		_, err = f.WriteAt([]byte("tail"), BEBlockSize*3+10)
+		assertNoErr(err, t)
+		assertNoErr(f.Close(), t)
+	}
+	read := func(name string, policy SparsePolicy) ([]byte, error) {
+		f, err := Open(filepath.Join(dir, name), WithPassword([]byte(password)), WithSparse(policy))
+		assertNoErr(err, t)
+		defer func() { _ = f.Close() }()
+		return io.ReadAll(f)
+	}
+	expected := append(make([]byte, BEBlockSize*3+10), []byte("tail")...)
+
+	write("holes", SparseStrict)
+	if _, err := read("holes", SparseStrict); err == nil {
+		t.Fatal("holes should fail to read")
+	}
+	if read, err := read("holes", SparseZeros); err != nil || !bytes.Equal(read, expected) {
+		t.Fatal("holes should read as zeros", err)
+	}
+
+	write("filled", SparseFill)
+	if read, err := read("filled", SparseStrict); err != nil || !bytes.Equal(read, expected) {
+		t.Fatal("filled file should have no holes", err)
+	}
+}
+
+func TestOptions_Durability(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.seof")
+	for _, durability := range []Durability{DurabilityNone, DurabilityClose, DurabilityFlush, DurabilitySync} {
+		f, err := Create(name, WithPassword([]byte(password)), WithKDF(crypto.MinSCryptParameters),
+			WithBlockSize(BEBlockSize), WithDurability(durability))
+		assertNoErr(err, t)
+		_, err = f.Write(crypto.RandBytes(BEBlockSize * 3))
+		assertNoErr(err, t)
+		assertNoErr(f.Close(), t)
+	}
+	f, err := Open(name, WithPassword([]byte(password)), WithDurability(DurabilitySync))
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+}
+
+func TestOptions_RandAndLogger(t *testing.T) {
+	dir := t.TempDir()
+	create := func(name string) []byte {
+		f, err := Create(filepath.Join(dir, name), WithPassword([]byte(password)), WithKDF(crypto.MinSCryptParameters),
+			WithBlockSize(BEBlockSize), WithRand(bytes.NewReader(bytes.Repeat([]byte{42}, 1<<16))))
+		assertNoErr(err, t)
+		_, err = f.Write([]byte("hello"))
+		assertNoErr(err, t)
+		assertNoErr(f.Close(), t)
+		b, _ := os.ReadFile(filepath.Join(dir, name))
+		return b
+	}
+	if !bytes.Equal(create("a"), create("b")) {
+		t.Fatal("files should be equal with the same randomness")
+	}
+
+	var log strings.Builder
+	f, err := Open(filepath.Join(dir, "a"), WithPassword([]byte(password)), WithLogger(slog.New(slog.NewTextHandler(&log, nil))))
+	assertNoErr(err, t)
+	_ = f.file.Close()
+	f.setPendingErr(os.ErrClosed)
+	if !strings.Contains(log.String(), "could not write") || !strings.Contains(log.String(), "file="+filepath.Join(dir, "a")) {
+		t.Fatal(log.String())
+	}
+}
+
+func TestOptions_RandFailing(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.seof")
+	opts := []Option{WithPassword([]byte(password)), WithKDF(crypto.MinSCryptParameters), WithBlockSize(BEBlockSize), WithCacheSize(1)}
+	if _, err := Create(name, append(opts, WithRand(bytes.NewReader(nil)))...); err == nil {
+		t.Fatal("creating should fail without randomness")
+	}
+
+	// randomness runs out while writing: blocks are not written, the error is returned instead of panicking
+	f, err := Create(name, append(opts, WithRand(bytes.NewReader(bytes.Repeat([]byte{42}, 1024))))...)
+	assertNoErr(err, t)
+	for i := 0; i < 64 && err == nil; i++ {
+		_, err = f.Write(crypto.RandBytes(BEBlockSize))
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil || !strings.Contains(err.Error(), "could not generate randomness") {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,249 @@
+package seof
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// ParityParameters configures the optional Reed-Solomon erasure coding layer: Parity blocks are written for every Data
+// blocks (a group), up to Parity damaged blocks within a group can be reconstructed.
+//
+// Parity is calculated over the encrypted blocks as stored in disk, so it does not leak anything the encrypted blocks
+// do not leak already. It is brought up to date on Sync and Close.
+type ParityParameters struct {
+	Data   uint8
+	Parity uint8
+}
+
+var NoParity = ParityParameters{}
+
+func (p ParityParameters) Enabled() bool {
+	return p != NoParity
+}
+
+func (p ParityParameters) Verify() error {
+	if p.Data < 1 || p.Data > 64 {
+		return errors.New("parity data blocks can be between 1 and 64")
+	}
+	if p.Parity < 1 || p.Parity > 16 {
+		return errors.New("parity blocks can be between 1 and 16")
+	}
+	return nil
+}
+
+func (f *File) initialiseParity() error {
+	if f.ext.ParityData == 0 {
+		return nil
+	}
+	var err error
+	f.rs, err = reedsolomon.New(int(f.ext.ParityData), int(f.ext.ParityShards))
+	f.staleGroups = make(map[int64]bool)
+	return err
+}
+
+// blockOffset returns the disk offset for a block, when parity is enabled, each group of data blocks is followed by
+// its parity blocks.
+func (f *File) blockOffset(blockNo int64) int64 {
+	slot := blockNo
+	if f.rs != nil {
+		n, k := int64(f.ext.ParityData), int64(f.ext.ParityShards)
+		slot = blockNo/n*(n+k) + blockNo%n
+	}
+	return f.dataOffset + int64(f.blockZero.DiskBlockSize)*slot
+}
+
+func (f *File) parityOffset(group int64, shard int64) int64 {
+	n, k := int64(f.ext.ParityData), int64(f.ext.ParityShards)
+	return f.dataOffset + int64(f.blockZero.DiskBlockSize)*(group*(n+k)+n+shard)
+}
+
+// diskSizeForBlocks returns the disk file size required to hold the given quantity of blocks (block zero included)
+func (f *File) diskSizeForBlocks(blocks int64) int64 {
+	if f.rs == nil || blocks == 0 {
+		return f.dataOffset + int64(f.blockZero.DiskBlockSize)*blocks
+	}
+	n, k := int64(f.ext.ParityData), int64(f.ext.ParityShards)
+	groups := (blocks-1)/n + 1
+	return f.dataOffset + int64(f.blockZero.DiskBlockSize)*groups*(n+k)
+}
+
+func (f *File) lastBlockNo() int64 {
+	if f.blockZero.BEncFileSize == 0 {
+		return 0
+	}
+	return f.blockNoForOffset(int64(f.blockZero.BEncFileSize) - 1)
+}
+
+func (f *File) markParityStale(blockNo int64) {
+	if f.rs != nil {
+		f.staleGroups[blockNo/int64(f.ext.ParityData)] = true
+	}
+}
+
+// readSlot reads a whole block from disk as is, missing bytes (i.e. sparse or past the end of the file) read as zeroes
+func (f *File) readSlot(offset int64) ([]byte, error) {
+	slot := make([]byte, f.blockZero.DiskBlockSize)
+	_, err := f.file.ReadAt(slot, offset)
+	if err == io.EOF {
+		err = nil
+	}
+	return slot, err
+}
+
+func (f *File) unsealSlot(slot []byte, blockNo int64) ([]byte, error) {
+	return f.unsealSlotAD(slot, f.additionalData(uint64(blockNo), false))
+}
+
+func (f *File) unsealSlotAD(slot []byte, additional []byte) ([]byte, error) {
+	cipherTextLen := int(binary.LittleEndian.Uint32(slot[nonceSize : nonceSize+4]))
+	if cipherTextLen > len(slot)-nonceSize-4 {
+		return nil, errors.New("invalid cipherText length")
+	}
+	return f.unsealAD(slot[nonceSize+4:nonceSize+4+cipherTextLen], additional, slot[0:nonceSize])
+}
+
+// updateParity recalculates the parity blocks for all the groups modified since the last update, errors are pending
+// errors too
+func (f *File) updateParity() error {
+	if f.rs == nil {
+		return nil
+	}
+	n, k := int64(f.ext.ParityData), int64(f.ext.ParityShards)
+	lastGroup := f.lastBlockNo() / n
+	for group := range f.staleGroups {
+		delete(f.staleGroups, group)
+		if group > lastGroup {
+			continue
+		}
+		shards := make([][]byte, n+k)
+		var err error
+		for i := int64(0); i < n; i++ {
+			shards[i], err = f.readSlot(f.blockOffset(group*n + i))
+			if err != nil {
+				f.setPendingErr(err)
+				return err
+			}
+		}
+		for i := n; i < n+k; i++ {
+			shards[i] = make([]byte, f.blockZero.DiskBlockSize)
+		}
+		err = f.rs.Encode(shards)
+		if err != nil {
+			f.setPendingErr(err)
+			return err
+		}
+		for i := int64(0); i < k; i++ {
+			_, err = f.file.WriteAt(shards[n+i], f.parityOffset(group, i))
+			if err != nil {
+				f.setPendingErr(err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// repairBlock tries to reconstruct a block that could not be read using its group's parity blocks. Other damaged
+// blocks in the group are treated as erasures as well, all the blocks successfully reconstructed are written back
+// (unless opened read only).
+// Zeroed blocks are first taken as sparse holes (zero when encoded too), then as damaged blocks (i.e. zeroed by a
+// failing disk). If the block can't be repaired, the original cause is returned.
+func (f *File) repairBlock(blockNo int64, cause error) ([]byte, error) {
+	n, k := int64(f.ext.ParityData), int64(f.ext.ParityShards)
+	group := blockNo / n
+	slots := make([][]byte, n+k)
+	for i := int64(0); i < n+k; i++ {
+		var offset int64
+		if i < n {
+			offset = f.blockOffset(group*n + i)
+		} else {
+			offset = f.parityOffset(group, i-n)
+		}
+		slot, err := f.readSlot(offset)
+		if err != nil {
+			return nil, cause
+		}
+		slots[i] = slot
+	}
+	for _, zeroedAreHoles := range []bool{true, false} {
+		if plainText, ok := f.reconstructBlock(blockNo, slots, zeroedAreHoles); ok {
+			return plainText, nil
+		}
+	}
+	return nil, cause
+}
+
+// reconstructBlock reconstructs the damaged blocks of the group from its slots (data and parity), writing them back
+// when the file is writable
+func (f *File) reconstructBlock(blockNo int64, slots [][]byte, zeroedAreHoles bool) ([]byte, bool) {
+	n, k := int64(f.ext.ParityData), int64(f.ext.ParityShards)
+	group := blockNo / n
+	shards := make([][]byte, n+k)
+	for i := range slots {
+		shards[i] = append([]byte{}, slots[i]...) // reconstruction fills the missing shards in place
+	}
+
+	missing := make([]bool, n)
+	missing[blockNo-group*n] = true
+	missingCount := int64(1)
+	for i := int64(0); i < n; i++ {
+		hole := isZeroed(shards[i]) && (zeroedAreHoles || group*n+i > f.lastBlockNo()) // past the end, never written
+		if !missing[i] && !hole {
+			if _, err := f.unsealSlot(shards[i], group*n+i); err != nil {
+				missing[i] = true
+				missingCount++
+			}
+		}
+		if missing[i] {
+			shards[i] = nil
+		}
+	}
+	if missingCount > k {
+		return nil, false
+	}
+	if err := f.rs.ReconstructData(shards); err != nil {
+		return nil, false
+	}
+
+	var plainText []byte
+	repaired := false
+	for i := int64(0); i < n; i++ {
+		if !missing[i] {
+			continue
+		}
+		pt, err := f.unsealSlot(shards[i], group*n+i)
+		if err != nil {
+			continue
+		}
+		written := f.rewriteSlot(f.blockOffset(group*n+i), shards[i])
+		f.observe(BlockRepaired, 1, 0)
+		f.logger().Warn("block repaired", "block", group*n+i, "written", written)
+		if group*n+i == blockNo {
+			plainText, repaired = pt, true
+		}
+	}
+	return plainText, repaired
+}
+
+// rewriteSlot writes back a repaired block, best effort, returning if it was written. Files opened read only are not
+// modified: the block is repaired in memory only, and again when read next time (i.e. until verified writable). The
+// repaired block is bit-by-bit the original one, so parity is still valid after rewriting it.
+func (f *File) rewriteSlot(offset int64, slot []byte) bool {
+	if !f.writable() {
+		return false
+	}
+	_, err := f.file.WriteAt(slot, offset)
+	return err == nil
+}
+
+func isZeroed(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
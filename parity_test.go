@@ -0,0 +1,206 @@
+package seof
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+var testParity = ParityParameters{Data: 4, Parity: 2}
+
+func corruptBlock(t *testing.T, f *File, blockNo int64) {
+	disk, err := os.OpenFile(f.Name(), os.O_RDWR, 0)
+	assertNoErr(err, t)
+	_, err = disk.WriteAt(crypto.RandBytes(100), f.blockOffset(blockNo)+20)
+	assertNoErr(err, t)
+	assertNoErr(disk.Close(), t)
+}
+
+func TestParity_RepairsAndRewritesBlocks(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	data := crypto.RandBytes(BEBlockSize*10 + BEBlockSize/2)
+	assertNoErr(givenFile(t, openWithPassword, tempFile.Name(), data, WithParity(testParity)).Close(), t)
+	pristine, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+
+	f, err := OpenFile(tempFile.Name(), os.O_RDWR, 0, WithPassword([]byte(password)), WithCacheSize(2))
+	assertNoErr(err, t)
+	// two damaged blocks in one group, one in the last (partial) group
+	corruptBlock(t, f, 5)
+	corruptBlock(t, f, 6)
+	corruptBlock(t, f, 11)
+
+	readBuf := make([]byte, len(data)*2)
+	n, err := f.Read(readBuf)
+	assertNoErr(err, t)
+	if n != len(data) || !bytes.Equal(data, readBuf[:n]) {
+		t.Fatal("repaired data does not match what was written")
+	}
+	if f.Stats().BlocksRepaired != 3 {
+		t.Fatal(f.Stats().BlocksRepaired)
+	}
+	assertNoErr(f.Close(), t)
+
+	repaired, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+	if !bytes.Equal(pristine, repaired) {
+		t.Fatal("repaired blocks should have been written back")
+	}
+}
+
+func TestParity_ReadOnlyRepairsInMemory(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	data := crypto.RandBytes(BEBlockSize * 6)
+	assertNoErr(givenFile(t, openWithPassword, tempFile.Name(), data, WithParity(testParity)).Close(), t)
+	f, err := OpenExt(tempFile.Name(), []byte(password), 2)
+	assertNoErr(err, t)
+	corruptBlock(t, f, 2)
+	damaged, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+
+	read, err := io.ReadAll(f)
+	assertNoErr(err, t)
+	if !bytes.Equal(data, read) || f.Stats().BlocksRepaired != 1 {
+		t.Fatal("the block should be repaired", f.Stats().BlocksRepaired)
+	}
+	assertNoErr(f.Close(), t)
+	if after, _ := os.ReadFile(tempFile.Name()); !bytes.Equal(damaged, after) {
+		t.Fatal("a read only file should not be modified")
+	}
+}
+
+func TestParity_RepairsBlockZero(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	data := crypto.RandBytes(BEBlockSize * 2)
+	assertNoErr(givenFile(t, openWithPassword, tempFile.Name(), data, WithParity(testParity)).Close(), t)
+	pristine, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+
+	f, err := OpenExt(tempFile.Name(), []byte(password), 2)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	corruptBlock(t, f, 0) // after closing, so it is not written again
+
+	ef, err := OpenFile(tempFile.Name(), os.O_RDWR, 0, WithPassword([]byte(password)), WithCacheSize(2))
+	assertNoErr(err, t)
+	stats, err := ef.Stat()
+	assertNoErr(err, t)
+	if stats.Size() != int64(len(data)) || stats.Parity() != testParity {
+		t.Fatal()
+	}
+	assertNoErr(ef.Close(), t)
+
+	repaired, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+	if !bytes.Equal(pristine, repaired) {
+		t.Fatal("block zero should have been repaired and written back")
+	}
+}
+
+func TestParity_TooManyDamagedBlocks(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	data := crypto.RandBytes(BEBlockSize * 10)
+	assertNoErr(givenFile(t, openWithPassword, tempFile.Name(), data, WithParity(testParity)).Close(), t)
+
+	f, err := OpenExt(tempFile.Name(), []byte(password), 2)
+	assertNoErr(err, t)
+	corruptBlock(t, f, 4)
+	corruptBlock(t, f, 5)
+	corruptBlock(t, f, 6)
+
+	readBuf := make([]byte, BEBlockSize)
+	_, err = f.ReadAt(readBuf, BEBlockSize*4)
+	if err == nil {
+		t.Fatal("three damaged blocks can not be repaired with two parity blocks")
+	}
+}
+
+func TestParity_TruncateAndRewrite(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	f, err := CreateExtParity(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, testParity, BEBlockSize, 10)
+	assertNoErr(err, t)
+	_, err = f.Write(crypto.RandBytes(BEBlockSize * 10))
+	assertNoErr(err, t)
+	assertNoErr(f.Truncate(BEBlockSize*2+10), t)
+	data := crypto.RandBytes(BEBlockSize * 3)
+	_, err = f.WriteAt(data, BEBlockSize*2+10)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	f, err = OpenExt(tempFile.Name(), []byte(password), 10)
+	assertNoErr(err, t)
+	corruptBlock(t, f, 4)
+	readBuf := make([]byte, len(data))
+	_, err = f.ReadAt(readBuf, BEBlockSize*2+10)
+	assertNoErr(err, t)
+	if !bytes.Equal(data, readBuf) {
+		t.Fatal()
+	}
+	stats, err := f.Stat()
+	assertNoErr(err, t)
+	if stats.EncryptedSize() != f.diskSizeForBlocks(f.lastBlockNo()+1) {
+		t.Fatal("disk file should end after the last group's parity blocks")
+	}
+	assertNoErr(f.Close(), t)
+}
+
+func TestParity_CloseReturnsParityErrors(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	f, err := CreateExtParity(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, testParity, BEBlockSize, 2)
+	assertNoErr(err, t)
+	_, err = f.Write(crypto.RandBytes(BEBlockSize * 3))
+	assertNoErr(err, t)
+	_ = f.file.Close()
+	f.file, _ = os.Open(tempFile.Name()) // parity can not be written
+	if err = f.Close(); err == nil {
+		t.Fatal("failing to write parity should be returned")
+	}
+}
+
+func TestParity_RepairsWithZeroedSibling(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	data := crypto.RandBytes(BEBlockSize * 10)
+	assertNoErr(givenFile(t, openWithPassword, tempFile.Name(), data, WithParity(testParity)).Close(), t)
+	pristine, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+
+	f, err := OpenFile(tempFile.Name(), os.O_RDWR, 0, WithPassword([]byte(password)), WithCacheSize(2))
+	assertNoErr(err, t)
+	// block 5 zeroed (not a hole, it was written) and block 6 damaged, in the same group
+	disk, err := os.OpenFile(f.Name(), os.O_RDWR, 0)
+	assertNoErr(err, t)
+	_, err = disk.WriteAt(make([]byte, f.blockZero.DiskBlockSize), f.blockOffset(5))
+	assertNoErr(err, t)
+	assertNoErr(disk.Close(), t)
+	corruptBlock(t, f, 6)
+
+	readBuf := make([]byte, BEBlockSize)
+	_, err = f.ReadAt(readBuf, BEBlockSize*5)
+	assertNoErr(err, t)
+	if !bytes.Equal(readBuf, data[BEBlockSize*5:BEBlockSize*6]) {
+		t.Fatal("repaired data does not match what was written")
+	}
+	assertNoErr(f.Close(), t)
+	repaired, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+	if !bytes.Equal(pristine, repaired) {
+		t.Fatal("both blocks should have been repaired")
+	}
+}
@@ -0,0 +1,24 @@
+package seof
+
+import "context"
+
+// KeyProvider wraps (encrypts) and unwraps data encryption keys with a key kept elsewhere, i.e. a KMS, Vault or an
+// in-house key service. Implementations are in the keyprovider package.
+type KeyProvider interface {
+	WrapKey(ctx context.Context, dek []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+const dataKeyLength = 32
+
+// CreateWithProvider creates a file encrypted with a new random data key, the key wrapped by the provider is stored in
+// the header extension. The file can be opened with OpenWithProvider.
+func CreateWithProvider(ctx context.Context, name string, provider KeyProvider, parity ParityParameters, BEBlockSize int, memoryBuffers int) (*File, error) {
+	return Create(name, WithContext(ctx), WithKeyProvider(provider), WithParity(parity), WithBlockSize(BEBlockSize),
+		WithCacheSize(memoryBuffers))
+}
+
+// OpenWithProvider opens a file created with CreateWithProvider, unwrapping its data key with the provider
+func OpenWithProvider(ctx context.Context, name string, provider KeyProvider, memoryBuffers int) (*File, error) {
+	return Open(name, WithContext(ctx), WithKeyProvider(provider), WithCacheSize(memoryBuffers))
+}
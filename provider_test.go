@@ -0,0 +1,87 @@
+package seof
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+// xorProvider is a toy KeyProvider, counting unwraps
+type xorProvider struct {
+	mask    byte
+	unwraps int
+}
+
+func (p *xorProvider) WrapKey(_ context.Context, dek []byte) ([]byte, error) {
+	wrapped := append([]byte("xor:"), dek...)
+	for i := 4; i < len(wrapped); i++ {
+		wrapped[i] ^= p.mask
+	}
+	return wrapped, nil
+}
+
+func (p *xorProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(wrapped, []byte("xor:")) {
+		return nil, errors.New("not wrapped by this provider")
+	}
+	p.unwraps++
+	dek := append([]byte{}, wrapped[4:]...)
+	for i := range dek {
+		dek[i] ^= p.mask
+	}
+	return dek, nil
+}
+
+func TestCreateWithProvider(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	ctx := context.Background()
+	provider := &xorProvider{mask: 0x5a}
+
+	data := crypto.RandBytes(BEBlockSize*2 + 89)
+	f, err := CreateWithProvider(ctx, tempFile.Name(), provider, NoParity, BEBlockSize, 1)
+	assertNoErr(err, t)
+	_, err = f.Write(data)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	f, err = OpenWithProvider(ctx, tempFile.Name(), provider, 1)
+	assertNoErr(err, t)
+	read, err := io.ReadAll(f)
+	assertNoErr(err, t)
+	if !bytes.Equal(data, read) || provider.unwraps != 1 {
+		t.Fatal()
+	}
+	assertNoErr(f.Close(), t)
+
+	if _, err = OpenWithProvider(ctx, tempFile.Name(), &xorProvider{mask: 0x33}, 1); err == nil {
+		t.Fatal("another provider key should not open the file")
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = OpenWithProvider(cancelled, tempFile.Name(), provider, 1); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected the provider error, got", err)
+	}
+	if _, err = OpenExt(tempFile.Name(), []byte(password), 1); err == nil {
+		t.Fatal("provider files should not open with a password")
+	}
+}
+
+func TestOpenWithProvider_KeyFile(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	f, err := CreateWithKey(tempFile.Name(), crypto.RandBytes(32), NoParity, BEBlockSize, 1)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	if _, err = OpenWithProvider(context.Background(), tempFile.Name(), &xorProvider{}, 1); err == nil {
+		t.Fatal("files without a wrapped key should not open with a provider")
+	}
+}
@@ -0,0 +1,68 @@
+package seof
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestCreateWithKey(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	key := crypto.RandBytes(32)
+
+	data := crypto.RandBytes(BEBlockSize*5 + 67)
+	f, err := CreateWithKey(tempFile.Name(), key, testParity, BEBlockSize, 2)
+	assertNoErr(err, t)
+	_, err = f.Write(data)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	f, err = OpenWithKey(tempFile.Name(), key, 2)
+	assertNoErr(err, t)
+	read, err := io.ReadAll(f)
+	assertNoErr(err, t)
+	if !bytes.Equal(data, read) {
+		t.Fatal()
+	}
+	stats, err := f.Stat()
+	assertNoErr(err, t)
+	if stats.KDF() != (crypto.HKDFParameters{}) || stats.Parity() != testParity {
+		t.Fatal()
+	}
+	assertNoErr(f.Close(), t)
+
+	if _, err = OpenWithKey(tempFile.Name(), crypto.RandBytes(32), 2); err == nil {
+		t.Fatal("wrong key should fail")
+	}
+	if _, err = OpenExt(tempFile.Name(), key, 2); err == nil {
+		t.Fatal("key based files can not be opened with a password")
+	}
+}
+
+func TestOpenWithKey_PasswordFile(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 1)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	if _, err = OpenWithKey(tempFile.Name(), []byte(password), 1); err == nil {
+		t.Fatal("password based files can not be opened with a key")
+	}
+}
+
+func TestCreateWithKey_InvalidArguments(t *testing.T) {
+	if _, err := CreateWithKey("file", crypto.RandBytes(31), NoParity, BEBlockSize, 1); err == nil {
+		t.Fatal("raw keys should be at least 32 bytes long")
+	}
+	if _, err := CreateWithKey("file", crypto.RandBytes(32), ParityParameters{Data: 1}, BEBlockSize, 1); err == nil {
+		t.Fatal("parity parameters should be checked")
+	}
+	if _, err := CreateExtParity("file", crypto.RandBytes(32), crypto.HKDFParameters{}, NoParity, BEBlockSize, 1); err == nil {
+		t.Fatal("raw keys should be used with CreateWithKey")
+	}
+}
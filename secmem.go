@@ -0,0 +1,104 @@
+package seof
+
+import (
+	"errors"
+	"sync"
+)
+
+// UseSecureMemory keeps the cached plaintext blocks in memory locked in RAM (never swapped), excluded from core dumps
+// (Linux) and surrounded by guard pages. Blocks are wiped when leaving the cache and the memory is wiped on Close.
+// Locked memory might be limited (see ulimit -l), it needs about (memoryBuffers+2) * block size bytes.
+//
+// Derived keys are always wiped after use. The AES key schedules are held by the Go runtime and can not be locked nor
+// wiped, their references are dropped on Close.
+func (f *File) UseSecureMemory() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.pendingErr != nil {
+		return *f.pendingErr
+	}
+	if f.secure != nil {
+		return nil
+	}
+	if f.buffers == 0 {
+		return errors.New("secure memory: not supported with a shared cache")
+	}
+	pool, err := newSecurePool(f.buffers+2, int(f.blockZero.BEncBlockSize))
+	if err != nil {
+		return err
+	}
+	f.secure = pool
+	for _, blockNo := range f.cache.Keys() {
+		if value, ok := f.cache.Peek(blockNo); ok {
+			imb := value.(*inMemoryBlock)
+			moved := f.newInMemoryBlock(imb.plainText)
+			imb.plainText, imb.secure = moved.plainText, moved.secure
+		}
+	}
+	return nil
+}
+
+// securePool hands out fixed size buffers from a memory region locked in RAM (not swapped), excluded from core dumps
+// where the OS allows it and surrounded by guard pages. Buffers are wiped when returned and the region when destroyed.
+type securePool struct {
+	mutex  sync.Mutex
+	region []byte // the whole mapping, guard pages included
+	data   []byte // the locked usable memory
+	size   int
+	free   [][]byte
+}
+
+func newSecurePool(count int, size int) (*securePool, error) {
+	if count < 1 || size < 1 {
+		return nil, errors.New("secure memory: invalid pool size")
+	}
+	region, data, err := allocLocked(count * size)
+	if err != nil {
+		return nil, err
+	}
+	p := securePool{region: region, data: data, size: size, free: make([][]byte, 0, count)}
+	for i := 0; i < count; i++ {
+		p.free = append(p.free, data[i*size:i*size:(i+1)*size])
+	}
+	return &p, nil
+}
+
+// get returns an empty buffer with capacity for size bytes, nil when the pool is exhausted (or destroyed)
+func (p *securePool) get() []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.free) == 0 {
+		return nil
+	}
+	b := p.free[len(p.free)-1]
+	p.free = p.free[:len(p.free)-1]
+	return b
+}
+
+// put wipes a buffer obtained with get, and returns it to the pool
+func (p *securePool) put(b []byte) {
+	b = b[:cap(b)]
+	wipe(b)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.data != nil {
+		p.free = append(p.free, b[:0])
+	}
+}
+
+// destroy wipes and releases the region, buffers obtained with get must not be used afterwards
+func (p *securePool) destroy() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.data == nil {
+		return nil
+	}
+	wipe(p.data)
+	err := freeLocked(p.region, p.data)
+	p.region, p.data, p.free = nil, nil, nil
+	return err
+}
+
+func wipe(b []byte) {
+	clear(b)
+}
@@ -0,0 +1,8 @@
+//go:build unix && !linux
+
+package seof
+
+// excludeFromDumps is not available, locked memory is still not swapped
+func excludeFromDumps(_ []byte) error {
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package seof
+
+import "errors"
+
+func allocLocked(_ int) (region []byte, data []byte, err error) {
+	return nil, nil, errors.New("secure memory: not supported in this platform")
+}
+
+func freeLocked(_ []byte, _ []byte) error {
+	return nil
+}
@@ -0,0 +1,89 @@
+package seof
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func TestSecurePool(t *testing.T) {
+	pool, err := newSecurePool(2, 100)
+	if err != nil {
+		t.Skip("secure memory not available:", err)
+	}
+	a, b := pool.get(), pool.get()
+	if len(a) != 0 || cap(a) != 100 || cap(b) != 100 || pool.get() != nil {
+		t.Fatal("expected two empty buffers of 100 bytes")
+	}
+	a = append(a, []byte("secret")...)
+	pool.put(a)
+	if a[:cap(a)][0] != 0 {
+		t.Fatal("returned buffers should be wiped")
+	}
+	if c := pool.get(); c == nil || cap(c) != 100 {
+		t.Fatal("returned buffers should be reused")
+	}
+	assertNoErr(pool.destroy(), t)
+	assertNoErr(pool.destroy(), t)
+	if pool.get() != nil {
+		t.Fatal("destroyed pool should not return buffers")
+	}
+}
+
+func TestFile_UseSecureMemory(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	data := crypto.RandBytes(BEBlockSize*10 + 321)
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 3)
+	assertNoErr(err, t)
+	_, err = f.Write(data[:BEBlockSize])
+	assertNoErr(err, t)
+	if err = f.UseSecureMemory(); err != nil {
+		t.Skip("secure memory not available:", err)
+	}
+	_, err = f.Write(data[BEBlockSize:])
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+
+	f, err = OpenExt(tempFile.Name(), []byte(password), 3)
+	assertNoErr(err, t)
+	assertNoErr(f.UseSecureMemory(), t)
+	read, err := io.ReadAll(f)
+	assertNoErr(err, t)
+	if !bytes.Equal(data, read) {
+		t.Fatal()
+	}
+	for _, blockNo := range f.cache.Keys() {
+		if imb, _ := f.cache.Peek(blockNo); !imb.(*inMemoryBlock).secure {
+			t.Fatal("cached blocks should be in secure memory")
+		}
+	}
+	assertNoErr(f.Close(), t)
+	if f.secure.data != nil {
+		t.Fatal("secure memory should be released on close")
+	}
+}
+
+func TestFile_SyncKeepsCachedBlocks(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 4)
+	assertNoErr(err, t)
+	_, err = f.Write([]byte("hello world"))
+	assertNoErr(err, t)
+	assertNoErr(f.Sync(), t)
+	_, err = f.WriteAt([]byte("H"), 0)
+	assertNoErr(err, t)
+	read := make([]byte, 11)
+	_, err = f.ReadAt(read, 0)
+	assertNoErr(err, t)
+	if string(read) != "Hello world" {
+		t.Fatalf("cached blocks should survive a sync, read: %q", read)
+	}
+	assertNoErr(f.Close(), t)
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package seof
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocLocked maps size bytes (rounded up to pages) between two inaccessible guard pages, locked in RAM
+func allocLocked(size int) (region []byte, data []byte, err error) {
+	page := os.Getpagesize()
+	dataLen := (size + page - 1) / page * page
+	region, err = unix.Mmap(-1, 0, dataLen+2*page, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, nil, err
+	}
+	data = region[page : page+dataLen]
+	if err = unix.Mprotect(region[:page], unix.PROT_NONE); err == nil {
+		err = unix.Mprotect(region[page+dataLen:], unix.PROT_NONE)
+	}
+	if err == nil {
+		err = unix.Mlock(data)
+	}
+	if err == nil {
+		err = excludeFromDumps(data)
+	}
+	if err != nil {
+		_ = unix.Munmap(region)
+		return nil, nil, err
+	}
+	return region, data, nil
+}
+
+func freeLocked(region []byte, data []byte) error {
+	if err := unix.Munlock(data); err != nil {
+		_ = unix.Munmap(region)
+		return err
+	}
+	return unix.Munmap(region)
+}
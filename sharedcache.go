@@ -0,0 +1,290 @@
+package seof
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// blockCache holds the cached blocks of a file: its own lru.Cache of memoryBuffers blocks, or its share of a
+// SharedCache. Blocks leaving it (evicted, removed or purged) are given to File.evictBlock, which flushes them.
+type blockCache interface {
+	Get(key interface{}) (value interface{}, ok bool)
+	Peek(key interface{}) (value interface{}, ok bool)
+	Add(key, value interface{}) (evicted bool)
+	Remove(key interface{}) (present bool)
+	Keys() []interface{}
+	Purge()
+	Len() int
+}
+
+// SharedCache is a block cache shared by many files, bounded in bytes (of plain text blocks) instead of blocks per file.
+// It uses the 2Q policy: blocks read once go to a FIFO queue (a quarter of the budget), blocks read again after being
+// evicted from it go to a LRU queue, so a long sequential read does not evict the blocks used repeatedly.
+//
+// Evicted blocks are flushed by their file, as with a file's own cache. Blocks of a file in use by another goroutine are
+// skipped: the cache can go over its budget while every block is in use, and always holds the block being used.
+type SharedCache struct {
+	mutex      sync.Mutex
+	maxBytes   int64
+	size       int64
+	inSize     int64
+	in         list.List // A1in: blocks read once, FIFO
+	main       list.List // Am: blocks read again, LRU
+	ghosts     list.List // A1out: blocks recently evicted from A1in, without their data
+	ghostIDs   map[sharedKey]*list.Element
+	ghostsSize int64
+}
+
+type sharedKey struct {
+	file    uint64
+	blockNo int64
+}
+
+type sharedEntry struct {
+	view   *sharedView
+	key    sharedKey
+	imb    *inMemoryBlock
+	size   int64
+	inMain bool
+}
+
+type ghost struct {
+	key  sharedKey
+	size int64
+}
+
+var sharedFileIDs atomic.Uint64
+
+// NewSharedCache returns a cache holding up to maxBytes of blocks, given to files with WithSharedCache
+func NewSharedCache(maxBytes int64) *SharedCache {
+	return &SharedCache{maxBytes: maxBytes, ghostIDs: map[sharedKey]*list.Element{}}
+}
+
+// Len returns the blocks in the cache
+func (c *SharedCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.in.Len() + c.main.Len()
+}
+
+// Size returns the bytes of the blocks in the cache
+func (c *SharedCache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+// view returns the file's share of the cache
+func (c *SharedCache) view(owner *File) *sharedView {
+	return &sharedView{cache: c, owner: owner, id: sharedFileIDs.Add(1), blocks: map[int64]*list.Element{}}
+}
+
+// sharedView is a file's share of a SharedCache, used with the file locked
+type sharedView struct {
+	cache  *SharedCache
+	owner  *File
+	id     uint64
+	blocks map[int64]*list.Element // guarded by cache.mutex
+}
+
+func (v *sharedView) Get(key interface{}) (interface{}, bool) {
+	c := v.cache
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := v.blocks[key.(int64)]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*sharedEntry)
+	if e.inMain {
+		c.main.MoveToFront(el)
+	}
+	return e.imb, true
+}
+
+func (v *sharedView) Peek(key interface{}) (interface{}, bool) {
+	c := v.cache
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := v.blocks[key.(int64)]
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(*sharedEntry).imb, true
+}
+
+// Add caches a block, evictions are counted by their files' stats (it always returns false)
+func (v *sharedView) Add(key, value interface{}) bool {
+	c := v.cache
+	blockNo, imb := key.(int64), value.(*inMemoryBlock)
+	c.mutex.Lock()
+	if el, ok := v.blocks[blockNo]; ok {
+		el.Value.(*sharedEntry).imb = imb
+		c.mutex.Unlock()
+		return false
+	}
+	e := &sharedEntry{
+		view: v,
+		key:  sharedKey{file: v.id, blockNo: blockNo},
+		imb:  imb,
+		size: max(int64(cap(imb.plainText)), int64(v.owner.blockZero.BEncBlockSize)),
+	}
+	var el *list.Element
+	if g, ok := c.ghostIDs[e.key]; ok { // read again, after leaving A1in
+		c.removeGhost(g)
+		e.inMain = true
+		el = c.main.PushFront(e)
+	} else {
+		el = c.in.PushFront(e)
+		c.inSize += e.size
+	}
+	v.blocks[blockNo] = el
+	c.size += e.size
+	victims, locked := c.reclaim(v, el)
+	c.mutex.Unlock()
+
+	for _, victim := range victims {
+		victim.view.owner.observe(Eviction, 1, 0)
+		victim.view.owner.evictBlock(victim.key.blockNo, victim.imb)
+	}
+	for _, other := range locked {
+		other.owner.mutex.Unlock()
+	}
+	return false
+}
+
+// reclaim removes blocks until the cache is within its budget, keeping the block being added. Blocks of other files
+// are taken only when their file can be locked without waiting, the locked views are returned to be unlocked after
+// flushing their blocks.
+func (c *SharedCache) reclaim(caller *sharedView, keep *list.Element) (victims []*sharedEntry, locked []*sharedView) {
+	evictable := func(l *list.List) *list.Element {
+		for el := l.Back(); el != nil; el = el.Prev() {
+			view := el.Value.(*sharedEntry).view
+			if el == keep {
+				continue
+			}
+			if view == caller || contains(locked, view) {
+				return el
+			}
+			if view.owner.mutex.TryLock() {
+				locked = append(locked, view)
+				return el
+			}
+		}
+		return nil
+	}
+	for c.size > c.maxBytes {
+		first, second := &c.main, &c.in
+		if c.inSize > c.maxBytes/4 {
+			first, second = second, first
+		}
+		el := evictable(first)
+		if el == nil {
+			el = evictable(second)
+		}
+		if el == nil {
+			break // every block is in use
+		}
+		e := el.Value.(*sharedEntry)
+		c.remove(el)
+		if !e.inMain {
+			c.addGhost(e)
+		}
+		victims = append(victims, e)
+	}
+	return victims, locked
+}
+
+func contains(views []*sharedView, view *sharedView) bool {
+	for _, v := range views {
+		if v == view {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *SharedCache) remove(el *list.Element) {
+	e := el.Value.(*sharedEntry)
+	if e.inMain {
+		c.main.Remove(el)
+	} else {
+		c.in.Remove(el)
+		c.inSize -= e.size
+	}
+	c.size -= e.size
+	delete(e.view.blocks, e.key.blockNo)
+}
+
+// addGhost remembers a block evicted from A1in, ghosts take no memory but are limited to half the budget of blocks
+func (c *SharedCache) addGhost(e *sharedEntry) {
+	c.ghostIDs[e.key] = c.ghosts.PushFront(&ghost{key: e.key, size: e.size})
+	c.ghostsSize += e.size
+	for c.ghostsSize > c.maxBytes/2 {
+		c.removeGhost(c.ghosts.Back())
+	}
+}
+
+func (c *SharedCache) removeGhost(el *list.Element) {
+	g := el.Value.(*ghost)
+	delete(c.ghostIDs, g.key)
+	c.ghostsSize -= g.size
+	c.ghosts.Remove(el)
+}
+
+func (v *sharedView) Remove(key interface{}) bool {
+	c := v.cache
+	c.mutex.Lock()
+	el, ok := v.blocks[key.(int64)]
+	if ok {
+		c.remove(el)
+	}
+	c.mutex.Unlock()
+	if ok {
+		e := el.Value.(*sharedEntry)
+		v.owner.evictBlock(e.key.blockNo, e.imb)
+	}
+	return ok
+}
+
+// Keys returns the file's blocks in order
+func (v *sharedView) Keys() []interface{} {
+	c := v.cache
+	c.mutex.Lock()
+	blockNos := make([]int64, 0, len(v.blocks))
+	for blockNo := range v.blocks {
+		blockNos = append(blockNos, blockNo)
+	}
+	c.mutex.Unlock()
+	sort.Slice(blockNos, func(i, j int) bool { return blockNos[i] < blockNos[j] })
+	keys := make([]interface{}, len(blockNos))
+	for i, blockNo := range blockNos {
+		keys[i] = blockNo
+	}
+	return keys
+}
+
+// Purge evicts the file's blocks and forgets its ghosts
+func (v *sharedView) Purge() {
+	for _, key := range v.Keys() {
+		v.Remove(key)
+	}
+	c := v.cache
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for el := c.ghosts.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*ghost).key.file == v.id {
+			c.removeGhost(el)
+		}
+		el = next
+	}
+}
+
+func (v *sharedView) Len() int {
+	v.cache.mutex.Lock()
+	defer v.cache.mutex.Unlock()
+	return len(v.blocks)
+}
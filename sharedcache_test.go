@@ -0,0 +1,153 @@
+package seof
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+func givenSharedView(c *SharedCache) *sharedView {
+	return c.view(&File{blockZero: BlockZero{BEncBlockSize: BEBlockSize}})
+}
+
+func addBlocks(v *sharedView, from int64, to int64) {
+	for blockNo := from; blockNo <= to; blockNo++ {
+		v.Add(blockNo, &inMemoryBlock{plainText: make([]byte, BEBlockSize)})
+	}
+}
+
+func TestSharedCache_ScanResistant(t *testing.T) {
+	c := NewSharedCache(8 * BEBlockSize)
+	hot, scan := givenSharedView(c), givenSharedView(c)
+	addBlocks(hot, 1, 2)
+	addBlocks(scan, 1, 9) // hot blocks are evicted, and remembered
+	if hot.Len() != 0 || c.Size() != 8*BEBlockSize {
+		t.Fatal(hot.Len(), c.Size())
+	}
+	addBlocks(hot, 1, 2) // read again
+	addBlocks(scan, 10, 1000)
+	if _, ok := hot.Get(int64(1)); !ok {
+		t.Fatal("hot block 1 should be cached")
+	}
+	if _, ok := hot.Get(int64(2)); !ok {
+		t.Fatal("hot block 2 should be cached")
+	}
+	if c.Size() != 8*BEBlockSize || c.Len() != 8 || scan.Len() != 6 {
+		t.Fatal(c.Size(), c.Len(), scan.Len())
+	}
+
+	hot.Purge()
+	scan.Purge()
+	if c.Len() != 0 || c.Size() != 0 || c.ghosts.Len() != 0 || len(c.ghostIDs) != 0 {
+		t.Fatal(c.Len(), c.Size(), c.ghosts.Len())
+	}
+}
+
+func TestSharedCache_FileInUse(t *testing.T) {
+	c := NewSharedCache(4 * BEBlockSize)
+	busy, other := givenSharedView(c), givenSharedView(c)
+	addBlocks(busy, 1, 4)
+	busy.owner.mutex.Lock() // in use by another goroutine
+	addBlocks(other, 1, 2)  // block 1 can be evicted, the cache goes over budget to hold block 2
+	if busy.Len() != 4 || other.Len() != 1 || c.Size() != 5*BEBlockSize {
+		t.Fatal("blocks of a file in use should not be evicted", busy.Len(), other.Len())
+	}
+	busy.owner.mutex.Unlock()
+	addBlocks(other, 3, 3)
+	if c.Size() != 4*BEBlockSize || busy.Len() != 2 || other.Len() != 2 {
+		t.Fatal(c.Size(), busy.Len(), other.Len())
+	}
+}
+
+func TestSharedCache_Files(t *testing.T) {
+	dir := t.TempDir()
+	c := NewSharedCache(16 * BEBlockSize)
+	files := 10
+	data := make([][]byte, files)
+	var wg sync.WaitGroup
+	errs := make(chan error, files)
+	for i := 0; i < files; i++ {
+		data[i] = crypto.RandBytes(BEBlockSize*20 + i)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := filepath.Join(dir, fmt.Sprint(i))
+			opts := []Option{WithPassword([]byte(password)), WithKDF(crypto.MinSCryptParameters), WithBlockSize(BEBlockSize),
+				WithSharedCache(c)}
+			f, err := Create(name, opts...)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for ofs := 0; ofs < len(data[i]); ofs += 100 {
+				if _, err = f.Write(data[i][ofs:min(ofs+100, len(data[i]))]); err != nil {
+					errs <- err
+					return
+				}
+			}
+			if err = f.Close(); err != nil {
+				errs <- err
+				return
+			}
+			if f, err = Open(name, opts...); err != nil {
+				errs <- err
+				return
+			}
+			defer func() { _ = f.Close() }()
+			if read, err := io.ReadAll(f); err != nil || !bytes.Equal(read, data[i]) {
+				errs <- fmt.Errorf("file %v does not read as written: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if c.Len() != 0 || c.Size() != 0 {
+		t.Fatal("closed files should leave no blocks", c.Len(), c.Size())
+	}
+}
+
+func TestSharedCache_Stats(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.seof")
+	c := NewSharedCache(2 * BEBlockSize)
+	f, err := Create(name, WithPassword([]byte(password)), WithKDF(crypto.MinSCryptParameters),
+		WithBlockSize(BEBlockSize), WithSharedCache(c))
+	assertNoErr(err, t)
+	_, err = f.Write(crypto.RandBytes(BEBlockSize*5 + 10))
+	assertNoErr(err, t)
+	stats := f.Stats()
+	if stats.Evictions != 4 || stats.DirtyFlushes != 4+1 || stats.CachedBlocks != 2 || stats.CacheSize != 0 {
+		t.Fatalf("%+v", stats)
+	}
+	if err = f.UseSecureMemory(); err == nil {
+		t.Fatal("secure memory is not supported with a shared cache")
+	}
+	assertNoErr(f.Close(), t)
+}
+
+func TestSharedCache_FailedOpen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "stream.seof")
+	out, err := os.Create(name)
+	assertNoErr(err, t)
+	s, err := NewStreamWriter(out, []byte(password), crypto.MinSCryptParameters, BEBlockSize)
+	assertNoErr(err, t)
+	_, err = s.Write(crypto.RandBytes(BEBlockSize * 3))
+	assertNoErr(err, t)
+	assertNoErr(out.Close(), t) // not closed, the trailer is missing
+
+	c := NewSharedCache(16 * BEBlockSize)
+	if _, err = Open(name, WithPassword([]byte(password)), WithSharedCache(c)); err == nil {
+		t.Fatal("a stream without trailer should not open")
+	}
+	if c.Len() != 0 || c.Size() != 0 {
+		t.Fatal("a failed open should leave no blocks", c.Len(), c.Size())
+	}
+}
@@ -0,0 +1,120 @@
+package seof
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Event is an operation observed by Hooks and counted in Stats
+type Event int
+
+const (
+	CacheHit      Event = iota // a block found in the cache
+	CacheMiss                  // a block read from disk
+	Eviction                   // a block evicted from a full cache
+	DirtyFlush                 // a modified block written to disk
+	BlockSealed                // a block encrypted
+	BlockUnsealed              // a block decrypted (or failing authentication)
+	BytesRead                  // plain text bytes read
+	BytesWritten               // plain text bytes written
+	KeyDerived                 // the key derived from the password (or raw key)
+	PendingError               // an error writing, returned by the following operations
+	BlockRepaired              // a damaged block reconstructed with parity, written back if opened for writing
+	eventCount
+)
+
+var eventNames = [eventCount]string{
+	"cache_hit", "cache_miss", "eviction", "dirty_flush", "block_sealed", "block_unsealed", "bytes_read",
+	"bytes_written", "key_derived", "pending_error", "block_repaired",
+}
+
+// String returns the event name in snake case, i.e. for expvar or Prometheus metric names
+func (e Event) String() string {
+	if e < 0 || e >= eventCount {
+		return "unknown"
+	}
+	return eventNames[e]
+}
+
+// Hooks observe the operations of a File, i.e. to feed expvar or Prometheus. Observe is called with the File locked, it
+// should be quick and not use the File. n is the bytes of BytesRead and BytesWritten, and 1 for the other events; d
+// is the time taken by BlockSealed, BlockUnsealed and KeyDerived.
+type Hooks interface {
+	Observe(event Event, n int, d time.Duration)
+}
+
+// Stats is a snapshot of the File counters since opened or created, see File.Stats
+type Stats struct {
+	CacheHits      uint64
+	CacheMisses    uint64
+	Evictions      uint64
+	DirtyFlushes   uint64
+	BlocksSealed   uint64
+	BlocksUnsealed uint64
+	BytesRead      uint64
+	BytesWritten   uint64
+	PendingErrors  uint64
+	BlocksRepaired uint64
+	KeyDerivation  time.Duration // scrypt, Argon2id or HKDF
+	SealTime       time.Duration
+	UnsealTime     time.Duration
+	CachedBlocks   int // blocks in the cache, of CacheSize (memoryBuffers, 0 with a shared cache)
+	CacheSize      int
+}
+
+type fileStats struct {
+	counts [eventCount]atomic.Uint64
+	times  [eventCount]atomic.Int64
+	hooks  atomic.Pointer[Hooks]
+}
+
+// Stats returns a snapshot of the file counters, it does not wait for operations in progress
+func (f *File) Stats() Stats {
+	s := &f.stats
+	stats := Stats{
+		CacheHits:      s.counts[CacheHit].Load(),
+		CacheMisses:    s.counts[CacheMiss].Load(),
+		Evictions:      s.counts[Eviction].Load(),
+		DirtyFlushes:   s.counts[DirtyFlush].Load(),
+		BlocksSealed:   s.counts[BlockSealed].Load(),
+		BlocksUnsealed: s.counts[BlockUnsealed].Load(),
+		BytesRead:      s.counts[BytesRead].Load(),
+		BytesWritten:   s.counts[BytesWritten].Load(),
+		PendingErrors:  s.counts[PendingError].Load(),
+		BlocksRepaired: s.counts[BlockRepaired].Load(),
+		KeyDerivation:  time.Duration(s.times[KeyDerived].Load()),
+		SealTime:       time.Duration(s.times[BlockSealed].Load()),
+		UnsealTime:     time.Duration(s.times[BlockUnsealed].Load()),
+		CacheSize:      f.buffers,
+	}
+	if f.cache != nil {
+		stats.CachedBlocks = f.cache.Len()
+	}
+	return stats
+}
+
+// SetHooks sets the hooks observing the file operations, nil removes them
+func (f *File) SetHooks(hooks Hooks) {
+	if hooks == nil {
+		f.stats.hooks.Store(nil)
+		return
+	}
+	f.stats.hooks.Store(&hooks)
+}
+
+func (f *File) observe(event Event, n int, d time.Duration) {
+	f.stats.counts[event].Add(uint64(n))
+	if d != 0 {
+		f.stats.times[event].Add(int64(d))
+	}
+	if hooks := f.stats.hooks.Load(); hooks != nil {
+		(*hooks).Observe(event, n, d)
+	}
+}
+
+// setPendingErr records an error writing, returned by the following operations
+func (f *File) setPendingErr(err error) {
+	f.pendingErr = &err
+	f.observe(PendingError, 1, 0)
+	f.logger().Error("could not write", "err", err)
+}
@@ -0,0 +1,68 @@
+package seof
+
+import (
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kuking/seof/crypto"
+)
+
+type countingHooks struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func (h *countingHooks) Observe(event Event, n int, _ time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.counts[event.String()] += n
+}
+
+func TestFile_Stats(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 2)
+	assertNoErr(err, t)
+	_, err = f.Write(crypto.RandBytes(BEBlockSize*5 + 10))
+	assertNoErr(err, t)
+	stats := f.Stats()
+	if stats.BytesWritten != BEBlockSize*5+10 || stats.Evictions != 4 || stats.DirtyFlushes != 4+1 ||
+		stats.BlocksSealed != stats.DirtyFlushes+1 || stats.CachedBlocks != 2 || stats.CacheSize != 2 ||
+		stats.KeyDerivation == 0 || stats.SealTime == 0 {
+		t.Fatalf("%+v", stats)
+	}
+	assertNoErr(f.Close(), t)
+
+	f, err = OpenFile(tempFile.Name(), os.O_RDWR, 0, WithPassword([]byte(password)), WithCacheSize(2))
+	assertNoErr(err, t)
+	hooks := &countingHooks{counts: map[string]int{}}
+	f.SetHooks(hooks)
+	_, err = io.ReadAll(f)
+	assertNoErr(err, t)
+	_, err = f.ReadAt(make([]byte, 10), BEBlockSize*5) // the last block, cached
+	assertNoErr(err, t)
+	stats = f.Stats() // block zero is read (and cached) on open
+	if stats.BytesRead != BEBlockSize*5+20 || stats.CacheMisses != 1+6 || stats.CacheHits < 1 ||
+		stats.BlocksUnsealed != stats.CacheMisses || stats.Evictions != 5 || stats.UnsealTime == 0 ||
+		stats.DirtyFlushes != 0 || stats.BlocksSealed != 0 {
+		t.Fatalf("%+v", stats)
+	}
+	// block zero was read before hooks were set
+	if hooks.counts["bytes_read"] != int(stats.BytesRead) || hooks.counts["cache_miss"] != int(stats.CacheMisses)-1 ||
+		hooks.counts["cache_hit"] != int(stats.CacheHits) || hooks.counts["eviction"] != int(stats.Evictions) {
+		t.Fatal(hooks.counts)
+	}
+
+	// writing fails
+	_, err = f.WriteAt([]byte{1}, 0)
+	assertNoErr(err, t)
+	_ = f.file.Close()
+	if err = f.Sync(); err == nil || f.Stats().PendingErrors == 0 || hooks.counts["pending_error"] != int(f.Stats().PendingErrors) {
+		t.Fatal(err)
+	}
+	f.SetHooks(nil)
+}
@@ -0,0 +1,385 @@
+package seof
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/kuking/seof/crypto"
+)
+
+// Streams: a StreamWriter encrypts into any io.Writer (pipes, sockets, stdout) strictly sequentially, so block zero can
+// not be updated once written. Every block takes a whole DiskBlockSize slot (padded with zeros) and the final block
+// zero (file size and attributes) goes into a trailer slot, sealed with block number math.MaxUint64. The stream
+// extension record tells OpenExt to read block zero from the trailer, a saved stream is a read only seof file. The
+// additional data of every stream block has a flag byte (see additionalData), the record can not be stripped.
+//
+// Archives (NewArchiveWriter) use the STREAM construction instead: the additional data of every block is its number
+// followed by a last block flag, set only in the trailer, which takes the block number after the last block. A stream
+// cut, reordered or extended fails to authenticate without relying on the sizes recorded in the trailer.
+
+const trailerBlockNo uint64 = math.MaxUint64
+
+// trailerAD returns the trailer additional data, blockNo is the number following the last block
+func (f *File) trailerAD(blockNo uint64) []byte {
+	if f.ext.LastBlockFlag {
+		return f.additionalData(blockNo, true)
+	}
+	return f.additionalData(trailerBlockNo, false)
+}
+
+type StreamWriter struct {
+	f       *File
+	w       io.Writer
+	buf     []byte
+	blockNo uint64
+	err     error
+}
+
+// NewStreamWriter writes the header and block zero into w, the rest of the stream is written as blocks fill up and on
+// Close. kdf is crypto.SCryptParameters or crypto.Argon2idParameters, streams have no parity.
+func NewStreamWriter(w io.Writer, password []byte, kdf crypto.KDFParameters, BEBlockSize int) (*StreamWriter, error) {
+	return newStreamWriter(w, password, kdf, HeaderExt{Stream: true}, BEBlockSize)
+}
+
+// NewArchiveWriter works as NewStreamWriter, using the STREAM construction: every block is bound to its position and
+// to being the last one or not, truncation is detected even without the trailer contents.
+func NewArchiveWriter(w io.Writer, password []byte, kdf crypto.KDFParameters, BEBlockSize int) (*StreamWriter, error) {
+	return newStreamWriter(w, password, kdf, HeaderExt{Stream: true, LastBlockFlag: true}, BEBlockSize)
+}
+
+func newStreamWriter(w io.Writer, password []byte, kdf crypto.KDFParameters, ext HeaderExt, BEBlockSize int) (*StreamWriter, error) {
+	if _, ok := kdf.(crypto.HKDFParameters); ok {
+		return nil, errors.New("stream: raw keys are not supported")
+	}
+	f, err := newFile(&Options{Context: context.Background(), KDF: kdf, BlockSize: BEBlockSize}, password, ext)
+	if err != nil {
+		return nil, err
+	}
+	f.flag = os.O_WRONLY // attributes are set, blocks are written by the StreamWriter
+	s := StreamWriter{f: f, w: w, buf: make([]byte, 0, BEBlockSize), blockNo: 1}
+	if err = f.writeHeaders(w); err != nil {
+		return nil, err
+	}
+	if err = s.writeSlot(f.blockZeroBytes(), f.additionalData(0, false)); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *StreamWriter) Write(b []byte) (n int, err error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	for len(b) > 0 {
+		copied := copy(s.buf[len(s.buf):cap(s.buf)], b)
+		s.buf = s.buf[:len(s.buf)+copied]
+		n += copied
+		b = b[copied:]
+		if len(s.buf) == cap(s.buf) {
+			if err = s.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// SetAttr sets an attribute (see File.SetAttr), attributes are written in the trailer on Close
+func (s *StreamWriter) SetAttr(key, value string) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.f.SetAttr(key, value)
+}
+
+// Close writes the last block and the trailer, the underlying writer is not closed. The stream can not be read back
+// if Close is not called (or fails).
+func (s *StreamWriter) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.buf) > 0 {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	s.f.blockZero.BlocksWritten++
+	if err := s.writeSlot(s.f.blockZeroBytes(), s.f.trailerAD(s.blockNo)); err != nil {
+		return err
+	}
+	s.err = os.ErrClosed
+	s.f.aead = [3]cipher.AEAD{}
+	return nil
+}
+
+func (s *StreamWriter) flush() error {
+	s.f.blockZero.BEncFileSize += uint64(len(s.buf))
+	s.f.blockZero.BlocksWritten++
+	err := s.writeSlot(s.buf, s.f.additionalData(s.blockNo, false))
+	s.blockNo++
+	wipe(s.buf)
+	s.buf = s.buf[:0]
+	return err
+}
+
+// writeSlot seals a block and writes it padded to DiskBlockSize
+func (s *StreamWriter) writeSlot(plainText []byte, additional []byte) error {
+	cipherText, nonce, err := s.f.sealAD(plainText, additional)
+	if err != nil {
+		s.err = err
+		return err
+	}
+	slot := make([]byte, s.f.blockZero.DiskBlockSize)
+	copy(slot, nonce)
+	binary.LittleEndian.PutUint32(slot[nonceSize:], uint32(len(cipherText)))
+	copy(slot[nonceSize+4:], cipherText)
+	if _, err := s.w.Write(slot); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+// loadTrailer reads the final block zero of a stream, checking the stream has all its blocks
+func (f *File) loadTrailer() ([]byte, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	slotSize := int64(f.header.DiskBlockSize)
+	length := info.Size() - f.dataOffset
+	if length < 2*slotSize || length%slotSize != 0 {
+		return nil, fmt.Errorf("stream: %w", ErrTruncated)
+	}
+	trailerNo, offset := length/slotSize-1, f.dataOffset+length-slotSize
+	plainText, err := f.loadBlockAt(offset, f.trailerAD(uint64(trailerNo)))
+	if err == errNotAuthentic || errors.Is(err, ErrTruncated) {
+		return nil, fmt.Errorf("stream: not closed: %w", ErrTruncated)
+	}
+	if err != nil {
+		return nil, err
+	}
+	bz, err := BlockZeroFromBytes(plainText)
+	if err != nil || bz.DiskBlockSize != f.header.DiskBlockSize || bz.BEncBlockSize == 0 {
+		return nil, &ErrCorruptBlock{BlockNo: trailerNo, Offset: offset, Err: errors.New("invalid trailer")}
+	}
+	blocks := (int64(bz.BEncFileSize) + int64(bz.BEncBlockSize) - 1) / int64(bz.BEncBlockSize)
+	if trailerNo != blocks+1 {
+		return nil, fmt.Errorf("stream: %v blocks, %v expected: %w", trailerNo-1, blocks, ErrTruncated)
+	}
+	return plainText, nil
+}
+
+// StreamReader decrypts a seof file, or a stream, reading strictly sequentially from any io.Reader (pipes, HTTP
+// bodies), it never seeks. Blocks are authenticated as they are read, the file size is verified at the end: a read
+// error other than io.EOF means the data read so far should not be trusted.
+type StreamReader struct {
+	f       *File
+	r       io.Reader
+	offset  int64 // bytes read, the position in the stream
+	blockNo uint64
+	read    uint64 // plaintext bytes decrypted
+	short   bool   // the last block read was not full, only valid for the last block of a stream
+	block   []byte
+	plain   []byte // the block part not yet returned
+	err     error
+}
+
+// NewStreamReader reads the headers and block zero from r, deriving the keys from the password. Raw key files are not
+// supported. Parity blocks are skipped, not used to repair damaged blocks.
+func NewStreamReader(r io.Reader, password []byte) (*StreamReader, error) {
+	f := File{}
+	counter := countingReader{r: r}
+	err := f.readHeaders(&counter)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.checkKeyType(false); err != nil {
+		return nil, err
+	}
+	header := f.header
+	if err = f.initialiseCiphers(context.Background(), password, &header); err != nil {
+		return nil, err
+	}
+	if err = f.initialiseParity(); err != nil {
+		return nil, err
+	}
+	f.blockZero = BlockZero{DiskBlockSize: header.DiskBlockSize}
+	f.dataOffset = counter.n
+	s := StreamReader{f: &f, r: r, offset: f.dataOffset}
+	plainText, err := s.readBlock(0)
+	if err != nil {
+		return nil, f.blockZeroError(err)
+	}
+	bz, err := BlockZeroFromBytes(plainText)
+	if err != nil || bz.DiskBlockSize != header.DiskBlockSize || bz.BEncBlockSize == 0 {
+		return nil, s.corrupt(0, errors.New("invalid block zero"))
+	}
+	f.blockZero = *bz
+	if !f.ext.Stream {
+		if f.attrs, err = attrsFromBlockZeroBytes(plainText); err != nil {
+			return nil, err
+		}
+	}
+	s.blockNo = 1
+	return &s, nil
+}
+
+func (s *StreamReader) Read(b []byte) (n int, err error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.err = s.next()
+	}
+	n = copy(b, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// GetAttr returns an attribute (see File.SetAttr), streams have them in the trailer: only once fully read
+func (s *StreamReader) GetAttr(key string) (string, bool) {
+	value, ok := s.f.attrs[key]
+	return value, ok
+}
+
+// Close drops the keys and wipes the current block, the underlying reader is not closed
+func (s *StreamReader) Close() error {
+	wipe(s.block)
+	s.block, s.plain = nil, nil
+	s.f.aead = [3]cipher.AEAD{}
+	s.err = os.ErrClosed
+	return nil
+}
+
+// next decrypts the next block, io.EOF when the end is reached and verified
+func (s *StreamReader) next() error {
+	if !s.f.ext.Stream && s.blockNo > uint64(s.f.lastBlockNo()) {
+		if s.read != s.f.blockZero.BEncFileSize {
+			return s.corrupt(int64(s.blockNo)-1, fmt.Errorf("%v bytes, %v expected: %w", s.read,
+				s.f.blockZero.BEncFileSize, ErrTruncated))
+		}
+		return io.EOF
+	}
+	wipe(s.block)
+	nonce, cipherText, err := s.readSlot(int64(s.blockNo))
+	if err != nil {
+		return err
+	}
+	plainText, err := s.f.unseal(cipherText, s.blockNo, nonce)
+	if err != nil && s.f.ext.Stream {
+		if trailer, trailerErr := s.f.unsealAD(cipherText, s.f.trailerAD(s.blockNo), nonce); trailerErr == nil {
+			return s.finish(trailer)
+		}
+	}
+	if err != nil {
+		return s.corrupt(int64(s.blockNo), err)
+	}
+	if s.f.ext.Stream {
+		if s.short || len(plainText) == 0 {
+			return s.corrupt(int64(s.blockNo), errors.New("invalid block size"))
+		}
+		s.short = len(plainText) < int(s.f.blockZero.BEncBlockSize)
+	} else if remaining := s.f.blockZero.BEncFileSize - s.read; uint64(len(plainText)) > remaining {
+		plainText = plainText[:remaining]
+	}
+	s.read += uint64(len(plainText))
+	s.blockNo++
+	s.block, s.plain = plainText, plainText
+	return nil
+}
+
+// finish verifies a stream trailer, it has to match what was read and be followed by nothing
+func (s *StreamReader) finish(trailer []byte) error {
+	bz, err := BlockZeroFromBytes(trailer)
+	if err != nil || bz.BEncBlockSize != s.f.blockZero.BEncBlockSize ||
+		bz.DiskBlockSize != s.f.blockZero.DiskBlockSize || bz.BEncFileSize != s.read {
+		return s.corrupt(int64(s.blockNo), errors.New("invalid trailer"))
+	}
+	if s.f.attrs, err = attrsFromBlockZeroBytes(trailer); err != nil {
+		return s.corrupt(int64(s.blockNo), err)
+	}
+	s.f.blockZero = *bz
+	if err = s.skip(s.f.blockOffset(int64(s.blockNo)) + int64(bz.DiskBlockSize) - s.offset); err != nil {
+		return err
+	}
+	if n, _ := io.ReadFull(s.r, make([]byte, 1)); n != 0 {
+		return s.corrupt(int64(s.blockNo)+1, errors.New("unexpected data after the trailer"))
+	}
+	return io.EOF
+}
+
+func (s *StreamReader) readBlock(blockNo int64) ([]byte, error) {
+	nonce, cipherText, err := s.readSlot(blockNo)
+	if err != nil {
+		return nil, err
+	}
+	plainText, err := s.f.unseal(cipherText, uint64(blockNo), nonce)
+	if err != nil {
+		return nil, s.corrupt(blockNo, err)
+	}
+	return plainText, nil
+}
+
+func (s *StreamReader) corrupt(blockNo int64, err error) error {
+	return &ErrCorruptBlock{BlockNo: blockNo, Offset: s.f.blockOffset(blockNo), Err: err}
+}
+
+// readSlot skips to the block and reads its nonce and cipherText, not the padding after them
+func (s *StreamReader) readSlot(blockNo int64) (nonce []byte, cipherText []byte, err error) {
+	if err = s.skip(s.f.blockOffset(blockNo) - s.offset); err != nil {
+		return
+	}
+	head := make([]byte, nonceSize+4)
+	if err = s.readFull(head); err != nil {
+		return
+	}
+	cipherTextLen := binary.LittleEndian.Uint32(head[nonceSize:])
+	if int64(cipherTextLen) > int64(s.f.blockZero.DiskBlockSize)-int64(nonceSize)-4 {
+		return nil, nil, s.corrupt(blockNo, errors.New("invalid cipherText length"))
+	}
+	cipherText = make([]byte, cipherTextLen)
+	if err = s.readFull(cipherText); err != nil {
+		return
+	}
+	return head[:nonceSize], cipherText, nil
+}
+
+func (s *StreamReader) readFull(b []byte) error {
+	n, err := io.ReadFull(s.r, b)
+	s.offset += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("stream: %w", ErrTruncated)
+	}
+	return err
+}
+
+func (s *StreamReader) skip(n int64) error {
+	if n < 0 {
+		return errors.New("stream: invalid offset")
+	}
+	skipped, err := io.CopyN(io.Discard, s.r, n)
+	s.offset += skipped
+	if err == io.EOF {
+		return fmt.Errorf("stream: %w", ErrTruncated)
+	}
+	return err
+}
+
+// countingReader counts the bytes read, where the headers end
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,313 @@
+package seof
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kuking/seof/crypto"
+)
+
+var streamWriters = []func(io.Writer, []byte, crypto.KDFParameters, int) (*StreamWriter, error){
+	NewStreamWriter, NewArchiveWriter,
+}
+
+func TestStreamWriter(t *testing.T) {
+	for _, newWriter := range streamWriters {
+		for _, size := range []int{0, 1, BEBlockSize, BEBlockSize*5 + 67} {
+			tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+			data := crypto.RandBytes(size)
+
+			s, err := newWriter(tempFile, []byte(password), crypto.MinSCryptParameters, BEBlockSize)
+			assertNoErr(err, t)
+			_, err = s.Write(data[:size/2])
+			assertNoErr(err, t)
+			_, err = s.Write(data[size/2:])
+			assertNoErr(err, t)
+			assertNoErr(s.SetAttr("content-type", "application/x-tar"), t)
+			assertNoErr(s.Close(), t)
+			if _, err = s.Write([]byte{1}); err == nil {
+				t.Fatal("closed stream should not be writable")
+			}
+
+			f, err := OpenExt(tempFile.Name(), []byte(password), 2)
+			assertNoErr(err, t)
+			read, err := io.ReadAll(f)
+			assertNoErr(err, t)
+			if !bytes.Equal(data, read) {
+				t.Fatal("size", size)
+			}
+			if value, _ := f.GetAttr("content-type"); value != "application/x-tar" {
+				t.Fatal()
+			}
+			assertNoErr(f.Verify(), t)
+			assertNoErr(f.Close(), t)
+			deferredCleanup(tempFile)
+		}
+	}
+}
+
+func TestStreamWriter_Truncated(t *testing.T) {
+	for _, newWriter := range streamWriters {
+		tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+		defer deferredCleanup(tempFile)
+		s, err := newWriter(tempFile, []byte(password), crypto.MinSCryptParameters, BEBlockSize)
+		assertNoErr(err, t)
+		_, err = s.Write(crypto.RandBytes(BEBlockSize * 3))
+		assertNoErr(err, t)
+		assertNoErr(s.Close(), t)
+		content, err := os.ReadFile(tempFile.Name())
+		assertNoErr(err, t)
+		slot := int(s.f.header.DiskBlockSize)
+		blockTwo := int(s.f.dataOffset) + 2*slot
+
+		for _, truncated := range [][]byte{
+			content[:len(content)-slot], // not closed
+			content[:len(content)-1],    // cut
+			append(append([]byte{}, content[:blockTwo]...), content[blockTwo+slot:]...), // a block missing
+		} {
+			assertNoErr(os.WriteFile(tempFile.Name(), truncated, 0600), t)
+			if _, err = OpenExt(tempFile.Name(), []byte(password), 2); err == nil {
+				t.Fatal("truncated stream should not open, length", len(truncated))
+			}
+		}
+	}
+}
+
+func TestStreamWriter_InvalidArguments(t *testing.T) {
+	if _, err := NewStreamWriter(io.Discard, crypto.RandBytes(32), crypto.HKDFParameters{}, BEBlockSize); err == nil {
+		t.Fatal("raw keys are not supported")
+	}
+	if _, err := NewStreamWriter(io.Discard, []byte("short"), crypto.MinSCryptParameters, BEBlockSize); err == nil {
+		t.Fatal("short passwords should fail")
+	}
+}
+
+func TestStreamReader_Stream(t *testing.T) {
+	for _, newWriter := range streamWriters {
+		for _, size := range []int{0, 1, BEBlockSize, BEBlockSize*5 + 67} {
+			data := crypto.RandBytes(size)
+			pr, pw := io.Pipe()
+			go func() {
+				s, err := newWriter(pw, []byte(password), crypto.MinSCryptParameters, BEBlockSize)
+				if err == nil {
+					_ = s.SetAttr("key", "value")
+					_, err = s.Write(data)
+				}
+				if err == nil {
+					err = s.Close()
+				}
+				_ = pw.CloseWithError(err)
+			}()
+
+			r, err := NewStreamReader(pr, []byte(password))
+			assertNoErr(err, t)
+			read, err := io.ReadAll(r)
+			assertNoErr(err, t)
+			if !bytes.Equal(data, read) {
+				t.Fatal("size", size)
+			}
+			if value, _ := r.GetAttr("key"); value != "value" {
+				t.Fatal()
+			}
+			assertNoErr(r.Close(), t)
+		}
+	}
+}
+
+func TestStreamReader_File(t *testing.T) {
+	for _, parity := range []ParityParameters{NoParity, testParity} {
+		for _, size := range []int{0, 1, BEBlockSize*4 - 1, BEBlockSize*9 + 67} {
+			tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+			data := crypto.RandBytes(size)
+			f, err := CreateExtParity(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, parity, BEBlockSize, 2)
+			assertNoErr(err, t)
+			assertNoErr(f.SetAttr("key", "value"), t)
+			_, err = f.Write(data)
+			assertNoErr(err, t)
+			assertNoErr(f.Close(), t)
+
+			r, err := NewStreamReader(tempFile, []byte(password))
+			assertNoErr(err, t)
+			if value, _ := r.GetAttr("key"); value != "value" {
+				t.Fatal()
+			}
+			read, err := io.ReadAll(r)
+			assertNoErr(err, t)
+			if !bytes.Equal(data, read) {
+				t.Fatal("size", size, "parity", parity)
+			}
+			deferredCleanup(tempFile)
+		}
+	}
+}
+
+func TestStreamReader_Invalid(t *testing.T) {
+	for _, newWriter := range streamWriters {
+		var stream bytes.Buffer
+		s, err := newWriter(&stream, []byte(password), crypto.MinSCryptParameters, BEBlockSize)
+		assertNoErr(err, t)
+		_, err = s.Write(crypto.RandBytes(BEBlockSize * 3))
+		assertNoErr(err, t)
+		assertNoErr(s.Close(), t)
+		content := stream.Bytes()
+		slot := int(s.f.header.DiskBlockSize)
+		blockTwo := int(s.f.dataOffset) + 2*slot
+
+		if _, err = NewStreamReader(bytes.NewReader(content), []byte("wrong password")); err == nil {
+			t.Fatal("wrong password should fail")
+		}
+		for _, invalid := range [][]byte{
+			content[:len(content)-slot], // not closed
+			content[:len(content)-1],    // cut
+			append(append([]byte{}, content[:blockTwo]...), content[blockTwo+slot:]...),          // a block missing
+			append(append([]byte{}, content[:blockTwo+slot]...), content[blockTwo:]...),          // a block repeated
+			append(append([]byte{}, content...), 0),                                              // appended data
+			append(append([]byte{}, content[:len(content)-slot]...), content[blockTwo-slot:]...), // appended blocks
+		} {
+			r, err := NewStreamReader(bytes.NewReader(invalid), []byte(password))
+			if err == nil {
+				_, err = io.ReadAll(r)
+			}
+			if err == nil {
+				t.Fatal("invalid stream should fail, length", len(invalid))
+			}
+		}
+	}
+}
+
+func TestArchiveWriter_LastBlockFlag(t *testing.T) {
+	var archive bytes.Buffer
+	s, err := NewArchiveWriter(&archive, []byte(password), crypto.MinSCryptParameters, BEBlockSize)
+	assertNoErr(err, t)
+	_, err = s.Write(crypto.RandBytes(BEBlockSize * 3))
+	assertNoErr(err, t)
+	aead := s.f.aead
+	assertNoErr(s.Close(), t)
+	s.f.aead = aead // dropped on Close
+	if !s.f.ext.LastBlockFlag || !s.f.ext.Stream {
+		t.Fatal()
+	}
+	slot := int(s.f.header.DiskBlockSize)
+	slotOf := func(blockNo int) []byte {
+		start := int(s.f.dataOffset) + blockNo*slot
+		return archive.Bytes()[start : start+slot]
+	}
+	ad := s.f.additionalData
+
+	// the trailer is block 4, and the only one flagged as last
+	if _, err = s.f.unsealSlotAD(slotOf(4), ad(4, true)); err != nil {
+		t.Fatal(err)
+	}
+	for _, invalid := range []struct {
+		blockNo int
+		ad      []byte
+	}{{4, ad(4, false)}, {4, ad(3, true)}, {3, ad(3, true)}, {3, ad(4, true)}, {3, ad(trailerBlockNo, false)}} {
+		if _, err = s.f.unsealSlotAD(slotOf(invalid.blockNo), invalid.ad); err == nil {
+			t.Fatal("should not authenticate:", invalid)
+		}
+	}
+}
+
+func TestStreamWriter_StrippedRecord(t *testing.T) {
+	for _, newWriter := range streamWriters {
+		var stream bytes.Buffer
+		s, err := newWriter(&stream, []byte(password), crypto.MinSCryptParameters, BEBlockSize)
+		assertNoErr(err, t)
+		_, err = s.Write(crypto.RandBytes(BEBlockSize * 2))
+		assertNoErr(err, t)
+		assertNoErr(s.Close(), t)
+
+		// an empty extension, as if the stream records were never there
+		stripped := append([]byte{}, stream.Bytes()[:HeaderLength]...)
+		stripped = append(stripped, 0, 0, 0, 0)
+		stripped = append(stripped, stream.Bytes()[s.f.dataOffset:]...)
+
+		if r, err := NewStreamReader(bytes.NewReader(stripped), []byte(password)); err == nil {
+			read, err := io.ReadAll(r)
+			t.Fatal("stripped stream should not authenticate, read", len(read), err)
+		}
+		name := t.TempDir() + "/stripped.seof"
+		assertNoErr(os.WriteFile(name, stripped, 0600), t)
+		if f, err := OpenExt(name, []byte(password), 2); err == nil {
+			read, err := io.ReadAll(f)
+			t.Fatal("stripped stream should not authenticate, read", len(read), err)
+		}
+	}
+}
+
+func TestStreamWriter_ReadOnly(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	s, err := NewStreamWriter(tempFile, []byte(password), crypto.MinSCryptParameters, BEBlockSize)
+	assertNoErr(err, t)
+	_, err = s.Write(crypto.RandBytes(5000))
+	assertNoErr(err, t)
+	assertNoErr(s.Close(), t)
+
+	for _, flag := range []int{os.O_RDWR, os.O_WRONLY} {
+		if _, err = OpenFile(tempFile.Name(), flag, 0, WithPassword([]byte(password))); err == nil {
+			t.Fatal("streams should not open for writing, flag", flag)
+		}
+	}
+	f, err := OpenFile(tempFile.Name(), os.O_RDONLY, 0, WithPassword([]byte(password)))
+	assertNoErr(err, t)
+	if info, _ := f.Stat(); info.Size() != 5000 {
+		t.Fatal(info.Size())
+	}
+	assertNoErr(f.Close(), t)
+}
+
+func TestStreamReader_RecordsOutOfOrder(t *testing.T) {
+	var archive bytes.Buffer
+	s, err := NewArchiveWriter(&archive, []byte(password), crypto.MinSCryptParameters, BEBlockSize)
+	assertNoErr(err, t)
+	data := crypto.RandBytes(BEBlockSize + 10)
+	_, err = s.Write(data)
+	assertNoErr(err, t)
+	assertNoErr(s.Close(), t)
+
+	// stream and last block flag records swapped, what is read does not serialise back as read
+	content := archive.Bytes()
+	records := content[HeaderLength+4 : s.f.dataOffset]
+	copy(records, append(append([]byte{}, records[4:8]...), records[0:4]...))
+
+	r, err := NewStreamReader(bytes.NewReader(content), []byte(password))
+	assertNoErr(err, t)
+	read, err := io.ReadAll(r)
+	assertNoErr(err, t)
+	if !bytes.Equal(data, read) {
+		t.Fatal()
+	}
+}
+
+func TestStreamReader_ShortLastBlock(t *testing.T) {
+	tempFile, _ := os.CreateTemp(os.TempDir(), "lala")
+	defer deferredCleanup(tempFile)
+	f, err := CreateExt(tempFile.Name(), []byte(password), crypto.MinSCryptParameters, BEBlockSize, 2)
+	assertNoErr(err, t)
+	_, err = f.Write(crypto.RandBytes(BEBlockSize*2 + 10))
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	before, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+
+	f, err = OpenFile(tempFile.Name(), os.O_RDWR, 0, WithPassword([]byte(password)))
+	assertNoErr(err, t)
+	_, err = f.WriteAt(crypto.RandBytes(10), BEBlockSize*2+10)
+	assertNoErr(err, t)
+	assertNoErr(f.Close(), t)
+	after, err := os.ReadFile(tempFile.Name())
+	assertNoErr(err, t)
+
+	// block zero records 10 bytes more than the (authentic) previous version of the last block has
+	lastBlock := f.blockOffset(3)
+	short := append(append([]byte{}, after[:lastBlock]...), before[lastBlock:]...)
+	r, err := NewStreamReader(bytes.NewReader(short), []byte(password))
+	assertNoErr(err, t)
+	if _, err = io.ReadAll(r); !errors.Is(err, ErrTruncated) {
+		t.Fatal("a short last block should be detected:", err)
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 
 	"github.com/kuking/seof/crypto"
 )
 
 const HeaderMagic uint64 = 0xb0a713c
+const HeaderMagicExt uint64 = 0xb0a713d // header followed by a HeaderExt
 const HeaderLength int = 128
+const maxHeaderExtLength = 64 * 1024
 
 type Header struct {
 	Magic         uint64
@@ -22,42 +26,272 @@ type Header struct {
 }
 
 func (h *Header) Verify() error {
-	if h.Magic != HeaderMagic {
-		return errors.New("header: invalid magic")
+	if h.Magic != HeaderMagic && h.Magic != HeaderMagicExt {
+		if h.Magic>>4 == HeaderMagic>>4 { // the magic changes in the last digit for new versions
+			return unsupportedError("magic")
+		}
+		return &ErrInvalidHeader{Field: "magic"}
 	}
 	if h.DiskBlockSize < 1112 || h.DiskBlockSize > 196608 {
-		return errors.New("header: invalid disk_block_size")
+		return &ErrInvalidHeader{Field: "disk_block_size"}
 	}
 	for i := 0; i < len(h.ScriptSalt); i++ {
 		if h.ScriptSalt[i] != 0 {
 			break
 		}
 		if i == len(h.ScriptSalt)-1 {
-			return errors.New("header: zero salt")
+			return &ErrInvalidHeader{Field: "salt"}
 		}
 	}
 
-	if h.ScriptN > crypto.MaxSCryptParameters.N || h.ScriptN < crypto.MinSCryptParameters.N ||
-		h.ScriptR > crypto.MaxSCryptParameters.R || h.ScriptR < crypto.MinSCryptParameters.R ||
-		h.ScriptP > crypto.MaxSCryptParameters.P || h.ScriptP < crypto.MinSCryptParameters.P {
-		return errors.New("header: invalid scrypt parameters")
+	// with another key derivation function (see HeaderExt) scrypt parameters are zero
+	scryptParams := crypto.SCryptParameters{N: h.ScriptN, R: h.ScriptR, P: h.ScriptP}
+	if !(h.Magic == HeaderMagicExt && scryptParams == crypto.SCryptParameters{}) && scryptParams.Verify() != nil {
+		return &ErrInvalidHeader{Field: "scrypt_parameters"}
 	}
 
 	for i := 0; i < len(h.TailOfZeros); i++ {
 		if h.TailOfZeros[i] != 0 {
-			return errors.New("header: tail of zeros not correct")
+			return &ErrInvalidHeader{Field: "tail_of_zeros"}
 		}
 	}
 
 	return nil
 }
 
+// HeaderExt holds the optional header extensions, stored right after the header when its magic is HeaderMagicExt.
+// On disk: uint32 length, followed by records of: uint16 tag, uint16 value length, value.
+type HeaderExt struct {
+	ParityData    uint8
+	ParityShards  uint8
+	KDF           uint8 // KDFSCrypt (zero) uses the header parameters
+	Argon2id      crypto.Argon2idParameters
+	KeyID         string                   // raw key files only, see Keyring
+	WrappedKey    string                   // raw key files only, the key wrapped by a KeyProvider
+	Stream        bool                     // written by a StreamWriter, the final block zero is in the trailer
+	LastBlockFlag bool                     // streams only, STREAM construction: the trailer is flagged as the last block
+	KeyCheck      [keyCheckLength]byte     // zero unless created WithKeyCheck, see keyCheck
+	PasswordSalt  [passwordSaltLength]byte // zero unless created WithPasswordSalt, shared by files
+}
+
+const (
+	extTagParity     uint16 = 1
+	extTagKDF        uint16 = 2
+	extTagKeyID      uint16 = 3
+	extTagWrappedKey uint16 = 4
+	extTagStream     uint16 = 5
+	extTagLastBlock  uint16 = 6
+	extTagKeyCheck   uint16 = 7
+	extTagPwSalt     uint16 = 8
+)
+
+const maxKeyIDLength = 255
+const maxWrappedKeyLength = 8 * 1024
+
+// Key derivation function identifiers
+const (
+	KDFSCrypt   uint8 = 0
+	KDFArgon2id uint8 = 1
+	KDFRawKey   uint8 = 2 // raw key material, see CreateWithKey
+)
+
+func (e *HeaderExt) IsEmpty() bool {
+	return *e == HeaderExt{}
+}
+
+func (e *HeaderExt) Verify() error {
+	if e.ParityData != 0 || e.ParityShards != 0 {
+		if err := (ParityParameters{Data: e.ParityData, Parity: e.ParityShards}).Verify(); err != nil {
+			return &ErrInvalidHeader{Field: "parity"}
+		}
+	}
+	switch e.KDF {
+	case KDFSCrypt:
+		if e.Argon2id != (crypto.Argon2idParameters{}) {
+			return &ErrInvalidHeader{Field: "argon2id_parameters"}
+		}
+	case KDFArgon2id:
+		if err := e.Argon2id.Verify(); err != nil {
+			return &ErrInvalidHeader{Field: "argon2id_parameters"}
+		}
+	case KDFRawKey:
+		if e.Argon2id != (crypto.Argon2idParameters{}) {
+			return &ErrInvalidHeader{Field: "argon2id_parameters"}
+		}
+	default:
+		return unsupportedError("key derivation function")
+	}
+	if len(e.KeyID) > maxKeyIDLength || (e.KeyID != "" && e.KDF != KDFRawKey) {
+		return &ErrInvalidHeader{Field: "key_id"}
+	}
+	if len(e.WrappedKey) > maxWrappedKeyLength || (e.WrappedKey != "" && e.KDF != KDFRawKey) {
+		return &ErrInvalidHeader{Field: "wrapped_key"}
+	}
+	if e.Stream && e.ParityData != 0 { // streams do not support parity
+		return &ErrInvalidHeader{Field: "parity"}
+	}
+	if e.LastBlockFlag && !e.Stream { // only valid for streams
+		return &ErrInvalidHeader{Field: "last_block_flag"}
+	}
+	if e.PasswordSalt != ([passwordSaltLength]byte{}) && e.KDF == KDFRawKey { // only for password based files
+		return &ErrInvalidHeader{Field: "password_salt"}
+	}
+	return nil
+}
+
+func (e *HeaderExt) Bytes() []byte {
+	records := new(bytes.Buffer)
+	if e.ParityData != 0 {
+		writeRecord(records, extTagParity, []byte{e.ParityData, e.ParityShards})
+	}
+	if e.KDF != KDFSCrypt {
+		value := new(bytes.Buffer)
+		value.WriteByte(e.KDF)
+		if e.KDF == KDFArgon2id {
+			_ = binary.Write(value, binary.LittleEndian, &e.Argon2id)
+		}
+		writeRecord(records, extTagKDF, value.Bytes())
+	}
+	if e.KeyID != "" {
+		writeRecord(records, extTagKeyID, []byte(e.KeyID))
+	}
+	if e.WrappedKey != "" {
+		writeRecord(records, extTagWrappedKey, []byte(e.WrappedKey))
+	}
+	if e.Stream {
+		writeRecord(records, extTagStream, nil)
+	}
+	if e.LastBlockFlag {
+		writeRecord(records, extTagLastBlock, nil)
+	}
+	if e.KeyCheck != ([keyCheckLength]byte{}) {
+		writeRecord(records, extTagKeyCheck, e.KeyCheck[:])
+	}
+	if e.PasswordSalt != ([passwordSaltLength]byte{}) {
+		writeRecord(records, extTagPwSalt, e.PasswordSalt[:])
+	}
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(records.Len()))
+	buf.Write(records.Bytes())
+	return buf.Bytes()
+}
+
+func HeaderExtFromReader(r io.Reader) (*HeaderExt, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxHeaderExtLength {
+		return nil, &ErrInvalidHeader{Field: "extension_length"}
+	}
+	records := make([]byte, length)
+	if _, err := io.ReadFull(r, records); err != nil {
+		return nil, err
+	}
+	e := HeaderExt{}
+	seen := map[uint16]bool{}
+	err := forEachRecord(records, func(tag uint16, value []byte) error {
+		if seen[tag] { // a record read twice would not serialise back as read
+			return &ErrInvalidHeader{Field: "extension"}
+		}
+		seen[tag] = true
+		switch tag {
+		case extTagParity:
+			if len(value) != 2 {
+				return &ErrInvalidHeader{Field: "parity"}
+			}
+			e.ParityData, e.ParityShards = value[0], value[1]
+		case extTagKDF:
+			if len(value) < 1 {
+				return &ErrInvalidHeader{Field: "kdf"}
+			}
+			e.KDF = value[0]
+			switch {
+			case e.KDF == KDFArgon2id && len(value) == 1+binary.Size(e.Argon2id):
+				_ = binary.Read(bytes.NewReader(value[1:]), binary.LittleEndian, &e.Argon2id)
+			case e.KDF == KDFRawKey && len(value) == 1:
+			case e.KDF == KDFArgon2id || e.KDF == KDFRawKey:
+				return &ErrInvalidHeader{Field: "kdf"}
+			default:
+				return unsupportedError("key derivation function")
+			}
+		case extTagKeyID:
+			if len(value) == 0 {
+				return &ErrInvalidHeader{Field: "key_id"}
+			}
+			e.KeyID = string(value)
+		case extTagWrappedKey:
+			if len(value) == 0 {
+				return &ErrInvalidHeader{Field: "wrapped_key"}
+			}
+			e.WrappedKey = string(value)
+		case extTagStream:
+			if len(value) != 0 {
+				return &ErrInvalidHeader{Field: "stream"}
+			}
+			e.Stream = true
+		case extTagLastBlock:
+			if len(value) != 0 {
+				return &ErrInvalidHeader{Field: "last_block_flag"}
+			}
+			e.LastBlockFlag = true
+		case extTagKeyCheck:
+			if len(value) != keyCheckLength || bytes.Equal(value, make([]byte, keyCheckLength)) {
+				return &ErrInvalidHeader{Field: "key_check"}
+			}
+			copy(e.KeyCheck[:], value)
+		case extTagPwSalt:
+			if len(value) != passwordSaltLength || bytes.Equal(value, make([]byte, passwordSaltLength)) {
+				return &ErrInvalidHeader{Field: "password_salt"}
+			}
+			copy(e.PasswordSalt[:], value)
+		default: // unknown records might change how the file has to be read, it is not safe to ignore them
+			return unsupportedError(fmt.Sprintf("extension record %v", tag))
+		}
+		return nil
+	})
+	if err != nil {
+		var invalid *ErrInvalidHeader
+		if errors.As(err, &invalid) || errors.Is(err, ErrUnsupportedVersion) {
+			return nil, err
+		}
+		return nil, &ErrInvalidHeader{Field: "extension"} // truncated record
+	}
+	return &e, nil
+}
+
+// writeRecord appends a tag-length-value record, the format used for extensible metadata
+func writeRecord(buf *bytes.Buffer, tag uint16, value []byte) {
+	_ = binary.Write(buf, binary.LittleEndian, tag)
+	_ = binary.Write(buf, binary.LittleEndian, uint16(len(value)))
+	buf.Write(value)
+}
+
+func forEachRecord(records []byte, fn func(tag uint16, value []byte) error) error {
+	for len(records) > 0 {
+		if len(records) < 4 {
+			return errors.New("truncated record")
+		}
+		tag := binary.LittleEndian.Uint16(records[0:2])
+		valueLen := int(binary.LittleEndian.Uint16(records[2:4]))
+		if len(records) < 4+valueLen {
+			return errors.New("truncated record")
+		}
+		if err := fn(tag, records[4:4+valueLen]); err != nil {
+			return err
+		}
+		records = records[4+valueLen:]
+	}
+	return nil
+}
+
 type BlockEnvelop struct {
 	Nonce         [nonceSize]byte
 	CipherTextLen uint32
 	CipherText    []byte
 }
 
+// BlockZero is stored encrypted in block zero, optionally followed by metadata records (see attrs.go)
 type BlockZero struct {
 	BEncBlockSize uint32 //BEnc as 'Before Encryption'
 	DiskBlockSize uint32
@@ -65,6 +299,8 @@ type BlockZero struct {
 	BlocksWritten uint64
 }
 
+var blockZeroLength = binary.Size(BlockZero{})
+
 func (z *BlockZero) Bytes() []byte {
 	buf := new(bytes.Buffer)
 	_ = binary.Write(buf, binary.LittleEndian, z)
@@ -89,6 +89,16 @@ func TestHeader_ScriptParams(t *testing.T) {
 		t.Fatal()
 	}
 	h.ScriptR = crypto.MaxSCryptParameters.R
+
+	// zero, only with a header extension (holding the key derivation parameters)
+	h.ScriptN, h.ScriptR, h.ScriptP = 0, 0, 0
+	if h.Verify() == nil {
+		t.Fatal()
+	}
+	h.Magic = HeaderMagicExt
+	if h.Verify() != nil {
+		t.Fatal()
+	}
 }
 
 func TestHeader_DiskBlockSize(t *testing.T) {
@@ -134,6 +144,88 @@ func TestBlockZero_Serialising(t *testing.T) {
 
 }
 
+func TestHeaderExt_Serialising(t *testing.T) {
+	ext := HeaderExt{ParityData: 16, ParityShards: 2}
+	ext2, err := HeaderExtFromReader(bytes.NewReader(ext.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext != *ext2 || ext2.Verify() != nil {
+		t.Fatal()
+	}
+
+	ext = HeaderExt{KDF: KDFArgon2id, Argon2id: crypto.RecommendedArgon2idParameters}
+	ext2, err = HeaderExtFromReader(bytes.NewReader(ext.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext != *ext2 || ext2.Verify() != nil {
+		t.Fatal()
+	}
+
+	ext = HeaderExt{KDF: KDFRawKey, KeyID: "team-2021", WrappedKey: "wrapped"}
+	ext2, err = HeaderExtFromReader(bytes.NewReader(ext.Bytes()))
+	if err != nil || ext != *ext2 || ext2.Verify() != nil {
+		t.Fatal()
+	}
+
+	ext = HeaderExt{Stream: true, LastBlockFlag: true, KeyCheck: [keyCheckLength]byte{1, 2, 3}}
+	ext2, err = HeaderExtFromReader(bytes.NewReader(ext.Bytes()))
+	if err != nil || ext != *ext2 || ext2.Verify() != nil {
+		t.Fatal()
+	}
+
+	ext = HeaderExt{PasswordSalt: [passwordSaltLength]byte{1, 2, 3}}
+	ext2, err = HeaderExtFromReader(bytes.NewReader(ext.Bytes()))
+	if err != nil || ext != *ext2 || ext2.Verify() != nil {
+		t.Fatal()
+	}
+
+	empty := HeaderExt{}
+	if !empty.IsEmpty() || len(empty.Bytes()) != 4 {
+		t.Fatal()
+	}
+}
+
+func TestHeaderExt_Invalid(t *testing.T) {
+	for _, raw := range [][]byte{
+		{},                                   // no length
+		{4, 0, 0, 0, 1, 0},                   // truncated
+		{6, 0, 0, 0, 1, 0, 2, 0},             // truncated record
+		{5, 0, 0, 0, 1, 0, 1, 0, 4},          // invalid parity record
+		{6, 0, 0, 0, 99, 0, 2, 0, 4, 2},      // unknown record
+		{5, 0, 0, 0, 2, 0, 1, 0, 1},          // truncated key derivation record
+		{5, 0, 0, 0, 2, 0, 1, 0, 9},          // unknown key derivation function
+		{6, 0, 0, 0, 2, 0, 2, 0, 2, 0},       // invalid raw key record
+		{4, 0, 0, 0, 3, 0, 0, 0},             // empty key id
+		{5, 0, 0, 0, 5, 0, 1, 0, 1},          // invalid stream record
+		{5, 0, 0, 0, 6, 0, 1, 0, 1},          // invalid last block record
+		{6, 0, 0, 0, 7, 0, 2, 0, 1, 2},       // invalid key check record
+		{6, 0, 0, 0, 8, 0, 2, 0, 1, 2},       // invalid password salt record
+		{8, 0, 0, 0, 5, 0, 0, 0, 5, 0, 0, 0}, // repeated record
+		{1, 0, 1, 0},                         // too big
+	} {
+		if _, err := HeaderExtFromReader(bytes.NewReader(raw)); err == nil {
+			t.Fatal("should not parse:", raw)
+		}
+	}
+	for _, ext := range []HeaderExt{
+		{ParityData: 0, ParityShards: 2},
+		{KDF: KDFArgon2id},
+		{KDF: KDFSCrypt, Argon2id: crypto.MinArgon2idParameters},
+		{KDF: 7},
+		{KeyID: "password based"},
+		{WrappedKey: "password based"},
+		{ParityData: 4, ParityShards: 2, Stream: true},
+		{LastBlockFlag: true},
+		{KDF: KDFRawKey, PasswordSalt: [passwordSaltLength]byte{1}},
+	} {
+		if ext.Verify() == nil {
+			t.Fatal("should not verify:", ext)
+		}
+	}
+}
+
 func givenValidHeader() Header {
 	h := Header{
 		Magic:         HeaderMagic,
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package seof
+
+// syncDir does nothing, directories can not be opened to be synced in this platform
+func syncDir(string) error {
+	return nil
+}
@@ -0,0 +1,110 @@
+package seof
+
+import (
+	"time"
+)
+
+// flusher is the background goroutine writing old (MaxDirtyAge) or too many (MaxDirtyBlocks) modified blocks
+type flusher struct {
+	stop chan struct{}
+	kick chan struct{} // too many modified blocks
+}
+
+// markDirty records when a cached block was first modified since written
+func (f *File) markDirty(blockNo int64) {
+	if f.dirty == nil {
+		f.dirty = make(map[int64]time.Time)
+	}
+	if _, ok := f.dirty[blockNo]; !ok {
+		f.dirty[blockNo] = time.Now()
+	}
+}
+
+// wrote applies the write policy after modifying blocks
+func (f *File) wrote() {
+	switch {
+	case f.writePolicy == WriteThrough:
+		// a grown size is written on Sync and Close, on disk the file reads as before the appends meanwhile; a
+		// truncated one now, the blocks past it are gone
+		f.flushDirty(time.Time{}, f.attrsModified || f.blockZero.BEncFileSize < f.flushedSize)
+	case f.flusher != nil && f.maxDirtyBlocks > 0 && len(f.dirty) >= f.maxDirtyBlocks:
+		select {
+		case f.flusher.kick <- struct{}{}:
+		default: // already kicked
+		}
+	}
+}
+
+// flushDirty writes the blocks modified before the given time (all of them when zero), and block zero with blockZero
+// when the file size or the attributes changed. Parity is updated on Sync and Close.
+func (f *File) flushDirty(before time.Time, blockZero bool) {
+	flushed := false
+	for blockNo, since := range f.dirty {
+		if !before.IsZero() && since.After(before) {
+			continue
+		}
+		if imb, ok := f.cache.Peek(blockNo); ok {
+			f.flushBlock(blockNo, imb)
+			flushed = true
+		}
+		delete(f.dirty, blockNo)
+	}
+	if blockZero && (f.blockZero.BEncFileSize != f.flushedSize || f.attrsModified) {
+		f.flushBlockZero()
+		flushed = true
+	}
+	if flushed && f.durability == DurabilityFlush && f.pendingErr == nil {
+		if err := f.file.Sync(); err != nil {
+			f.setPendingErr(err)
+		}
+	}
+}
+
+// startFlusher starts the background goroutine for write-back limits, files opened for reading have nothing to write
+func (f *File) startFlusher() {
+	if f.writePolicy != WriteBack || (f.maxDirtyAge == 0 && f.maxDirtyBlocks == 0) || !f.writable() {
+		return
+	}
+	f.flusher = &flusher{stop: make(chan struct{}), kick: make(chan struct{}, 1)}
+	go f.flushInBackground(f.flusher)
+}
+
+// stopFlusher stops the background goroutine, without waiting for it (it might be waiting for the file lock)
+func (f *File) stopFlusher() {
+	if f.flusher != nil {
+		close(f.flusher.stop)
+		f.flusher = nil
+	}
+}
+
+// flushInBackground writes blocks older than half MaxDirtyAge every half MaxDirtyAge, so blocks are written within
+// MaxDirtyAge, and all the modified blocks when kicked for reaching MaxDirtyBlocks
+func (f *File) flushInBackground(fl *flusher) {
+	var tick <-chan time.Time
+	if f.maxDirtyAge > 0 {
+		ticker := time.NewTicker(max(f.maxDirtyAge/2, time.Millisecond))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		before := time.Time{}
+		select {
+		case <-fl.stop:
+			return
+		case <-fl.kick:
+		case now := <-tick:
+			before = now.Add(-f.maxDirtyAge / 2)
+		}
+		f.mutex.Lock()
+		select {
+		case <-fl.stop: // closed while waiting for the lock
+			f.mutex.Unlock()
+			return
+		default:
+		}
+		if f.pendingErr == nil {
+			f.flushDirty(before, true)
+		}
+		f.mutex.Unlock()
+	}
+}
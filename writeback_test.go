@@ -0,0 +1,126 @@
+package seof
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kuking/seof/crypto"
+)
+
+// readOnDisk reads the file with another handle, seeing only what was written to disk
+func readOnDisk(t *testing.T, name string) []byte {
+	f, err := OpenExt(name, []byte(password), 1)
+	assertNoErr(err, t)
+	defer func() { _ = f.Close() }()
+	read, err := io.ReadAll(f)
+	assertNoErr(err, t)
+	return read
+}
+
+func eventuallyOnDisk(t *testing.T, name string, expected []byte) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Equal(readOnDisk(t, name), expected) {
+		if time.Now().After(deadline) {
+			t.Fatal("modified blocks were not written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWritePolicy_WriteThrough(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.seof")
+	f := givenFile(t, openWithPassword, name, nil, WithCacheSize(16), WithWritePolicy(WriteThrough),
+		WithDurability(DurabilityFlush))
+	data := crypto.RandBytes(BEBlockSize*3 + 10)
+	sealed := f.Stats().BlocksSealed
+	_, err := f.Write(data)
+	assertNoErr(err, t)
+	// appending writes seal their blocks, the size is written on Sync
+	if f.Stats().BlocksSealed != sealed+4 || len(readOnDisk(t, name)) != 0 {
+		t.Fatal(f.Stats().BlocksSealed - sealed)
+	}
+	assertNoErr(f.Sync(), t)
+	if !bytes.Equal(readOnDisk(t, name), data) {
+		t.Fatal("writes should reach the disk")
+	}
+
+	sealed = f.Stats().BlocksSealed
+	_, err = f.WriteAt([]byte("hello"), 10)
+	assertNoErr(err, t)
+	copy(data[10:], "hello")
+	if f.Stats().BlocksSealed != sealed+1 || !bytes.Equal(readOnDisk(t, name), data) {
+		t.Fatal(f.Stats().BlocksSealed - sealed)
+	}
+
+	sealed = f.Stats().BlocksSealed
+	_, err = f.WriteAt([]byte("more"), int64(len(data)))
+	assertNoErr(err, t)
+	if f.Stats().BlocksSealed != sealed+1 || !bytes.Equal(readOnDisk(t, name), data) {
+		t.Fatal("the file on disk should read as before the append", f.Stats().BlocksSealed-sealed)
+	}
+	other, err := OpenExt(name, []byte(password), 1)
+	assertNoErr(err, t)
+	assertNoErr(other.Verify(), t)
+	assertNoErr(other.Close(), t)
+	data = append(data, "more"...)
+	assertNoErr(f.Sync(), t)
+	if !bytes.Equal(readOnDisk(t, name), data) {
+		t.Fatal()
+	}
+
+	assertNoErr(f.Truncate(BEBlockSize+5), t)
+	if !bytes.Equal(readOnDisk(t, name), data[:BEBlockSize+5]) {
+		t.Fatal("truncation should reach the disk")
+	}
+
+	assertNoErr(f.SetAttr("key", "value"), t)
+	other, err = OpenExt(name, []byte(password), 1)
+	assertNoErr(err, t)
+	value, ok := other.GetAttr("key")
+	assertNoErr(other.Close(), t)
+	if !ok || value != "value" {
+		t.Fatal("attributes should reach the disk")
+	}
+}
+
+func TestWritePolicy_MaxDirtyAge(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.seof")
+	f := givenFile(t, openWithPassword, name, nil, WithCacheSize(16), WithWriteBack(50*time.Millisecond, 0))
+	data := crypto.RandBytes(BEBlockSize*3 + 10)
+	_, err := f.Write(data)
+	assertNoErr(err, t)
+	eventuallyOnDisk(t, name, data)
+
+	_, err = f.WriteAt([]byte("hello"), BEBlockSize)
+	assertNoErr(err, t)
+	copy(data[BEBlockSize:], "hello")
+	eventuallyOnDisk(t, name, data)
+}
+
+func TestWritePolicy_MaxDirtyBlocks(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.seof")
+	f := givenFile(t, openWithPassword, name, nil, WithCacheSize(16), WithWriteBack(0, 3))
+	data := crypto.RandBytes(BEBlockSize * 2)
+	_, err := f.Write(data)
+	assertNoErr(err, t)
+	time.Sleep(50 * time.Millisecond)
+	if len(readOnDisk(t, name)) != 0 {
+		t.Fatal("under the limit, blocks should not be written")
+	}
+	more := crypto.RandBytes(BEBlockSize)
+	_, err = f.Write(more)
+	assertNoErr(err, t)
+	eventuallyOnDisk(t, name, append(data, more...))
+}
+
+func TestWritePolicy_Invalid(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file.seof")
+	for _, opt := range []Option{WithWritePolicy(WritePolicy(2)), WithWriteBack(-time.Second, 0), WithWriteBack(0, -1)} {
+		if _, err := Create(name, WithPassword([]byte(password)), opt); err == nil {
+			t.Fatal("write policy should be checked")
+		}
+	}
+}